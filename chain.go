@@ -3,6 +3,12 @@ package chain
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"runtime/pprof"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -15,131 +21,728 @@ type Stream[T any, R any] interface {
 	// Filter returns a stream of elements matching the given predicate
 	Filter(fn func(T) bool) Stream[T, R]
 
+	// Peek calls fn on each element as it flows past and forwards the element
+	// unchanged
+	Peek(fn func(T)) Stream[T, R]
+
 	// Reduce reduces the stream to a single value using the given function
 	Reduce(fn func(T, T) T) (T, error)
 
+	// ReduceCtx is like Reduce, but stops once ctx is done
+	ReduceCtx(ctx context.Context, fn func(T, T) T) (T, error)
+
+	// ReduceCtxFold reduces the stream with a combine function that is handed
+	// ctx and may fail, stopping once ctx is done
+	ReduceCtxFold(ctx context.Context, fn func(ctx context.Context, a, b T) (T, error)) (T, error)
+
 	// ForEach performs an action for each element in the stream
 	ForEach(fn func(T)) error
 
+	// ForEachCtx performs an action that may fail for each element, stopping
+	// at the first error or once ctx is done
+	ForEachCtx(ctx context.Context, fn func(T) error) error
+
 	// Collect gathers all elements into a slice
 	Collect(ctx context.Context) ([]T, error)
 
-	// Parallel enables parallel processing with the specified number of workers
+	// Count drains the stream and returns the number of elements
+	Count(ctx context.Context) (int, error)
+
+	// First returns the first element, and false if the stream is empty,
+	// stopping the pipeline as soon as it has it
+	First(ctx context.Context) (T, bool, error)
+
+	// Last returns the final element, and false if the stream is empty
+	Last(ctx context.Context) (T, bool, error)
+
+	// Find returns the first element satisfying fn, and false if there is
+	// none, stopping the pipeline as soon as it has it
+	Find(ctx context.Context, fn func(T) bool) (T, bool, error)
+
+	// Any reports whether some element satisfies fn, stopping the pipeline at
+	// the first one that does
+	Any(ctx context.Context, fn func(T) bool) (bool, error)
+
+	// All reports whether every element satisfies fn, stopping the pipeline
+	// at the first one that does not
+	All(ctx context.Context, fn func(T) bool) (bool, error)
+
+	// None reports whether no element satisfies fn, stopping the pipeline at
+	// the first one that does
+	None(ctx context.Context, fn func(T) bool) (bool, error)
+
+	// CollectPages gathers all elements into pages of pageSize elements
+	CollectPages(ctx context.Context, pageSize int) ([][]T, error)
+
+	// CollectAtLeast gathers all elements, failing if there are fewer than min
+	CollectAtLeast(ctx context.Context, min int) ([]T, error)
+
+	// CollectPoll gathers elements from a source that may have nothing ready
+	// for a while, ending after maxEmptyPolls consecutive empty polls
+	CollectPoll(ctx context.Context, maxEmptyPolls int) ([]T, error)
+
+	// CollectTimed gathers all elements along with how long each one took to
+	// get from WithTiming to the end of the pipeline
+	CollectTimed(ctx context.Context) ([]TimedElement[T], error)
+
+	// Seq2 returns an iterator over the elements, each paired with a nil
+	// error, followed by a final zero value and error if the pipeline fails
+	Seq2(ctx context.Context) iter.Seq2[T, error]
+
+	// ToChannel returns a channel delivering the elements, closed once the
+	// stream ends or ctx is done
+	ToChannel(ctx context.Context) <-chan T
+
+	// Snapshot gathers all elements and also returns a stream replaying them
+	Snapshot(ctx context.Context) ([]T, Stream[T, T], error)
+
+	// BridgeReader returns a reader streaming the rendered bytes of each element
+	BridgeReader(ctx context.Context, render func(w io.Writer, v T) error) io.ReadCloser
+
+	// WriteJSONArray writes all elements to w as a JSON array
+	WriteJSONArray(ctx context.Context, w io.Writer) error
+
+	// Parallel enables parallel processing with the specified number of
+	// workers. It returns a new stream and leaves the receiver unchanged.
 	Parallel(workers int) Stream[T, R]
+
+	// WithBuffer sets the capacity of the output channels of the following
+	// Map and Filter stages, which otherwise hold one element per worker
+	WithBuffer(n int) Stream[T, R]
+
+	// OrderedParallel is like Parallel, but Map and Filter stages emit their
+	// results in the order of their input
+	OrderedParallel(workers int) Stream[T, R]
+
+	// WithMaxDuration stops the whole pipeline with ErrDeadline after d
+	WithMaxDuration(d time.Duration) Stream[T, R]
+
+	// Take emits at most the first n elements and then stops the stages
+	// upstream of it, so it also terminates infinite generators
+	Take(n int) Stream[T, R]
+
+	// WithPprofLabels runs the workers of the following stages under pprof
+	// labels naming the stage and the worker
+	WithPprofLabels() Stream[T, R]
+
+	// Skip drops the first n elements and emits the rest
+	Skip(n int) Stream[T, R]
+
+	// TakeWhile emits elements until fn first returns false and then stops
+	// the stages upstream of it
+	TakeWhile(fn func(T) bool) Stream[T, R]
+
+	// DropWhile drops the leading elements for which fn returns true and
+	// emits everything from the first element for which it returns false
+	DropWhile(fn func(T) bool) Stream[T, R]
+
+	// WithContext stops the pipeline once ctx is done, failing it with
+	// ErrCancelled or ErrDeadline
+	WithContext(ctx context.Context) Stream[T, R]
+
+	// WithAck calls ack for every element once the next stage has taken it.
+	// Placed just before the terminal operation, it acknowledges the
+	// elements that made it through the whole pipeline.
+	WithAck(ack func(T) error) Stream[T, R]
+
+	// WithProvenance records which worker of each parallel Map and Filter
+	// stage after it handled each element, for debugging
+	WithProvenance() Stream[T, R]
+
+	// Provenance returns the workers recorded for elem by WithProvenance
+	Provenance(elem T) []Hop
+
+	// WithTiming stamps each element as it passes, so that CollectTimed can
+	// tell how long it took to reach the end of the pipeline. Apply it right
+	// after the source.
+	WithTiming() Stream[T, R]
+
+	// Lazy switches the following sequential stages to pull-based evaluation
+	Lazy() Stream[T, R]
+
+	// Debounce emits an element only once no newer element has arrived for d
+	Debounce(d time.Duration) Stream[T, R]
+
+	// SampleLast emits, every d, the latest element received in that interval
+	SampleLast(d time.Duration) Stream[T, R]
+
+	// ThrottleBurst paces elements to one per rate, letting up to burst
+	// elements through at once after a quiet period
+	ThrottleBurst(rate time.Duration, burst int) Stream[T, R]
+
+	// WithHeartbeat calls beat every d while the stream is idle
+	WithHeartbeat(d time.Duration, beat func()) Stream[T, R]
+
+	// WithKeepaliveElement emits ka every d while the stream is idle
+	WithKeepaliveElement(d time.Duration, ka T) Stream[T, R]
 }
 
 // stream implements the Stream interface
 type stream[T any, R any] struct {
-	source  chan T
+	source  <-chan T
 	workers int
+
+	// next pulls elements from an iterator-backed source. It is only set
+	// until the source is started by channel, or for the stages of a lazy
+	// pipeline, which never start a goroutine at all.
+	next func() (T, bool)
+	// size is the channel capacity used when next is started
+	size int
+	// lazy selects pull-based evaluation for the following stages
+	lazy bool
+	// pool, if set, provides the goroutines of parallel stages
+	pool *workerPool
+	// profile runs the workers of the following stages under pprof labels
+	profile bool
+	// trace, if set, records the workers each element went through
+	trace *provenance
+	// ordered makes parallel Map and Filter stages keep the source order
+	ordered bool
+	// buffer, if positive, is the output capacity of Map and Filter stages
+	// in place of the worker count
+	buffer int
+	// shared is set once next has been guarded by clone
+	shared bool
+
+	// state is shared by every stage derived from the same source
+	state *pipeline
+	// quit is fired by the consumer to tell the producers of source to stop
+	quit *signal
 }
 
-// NewSliceStream creates a new stream from a slice
-func NewSliceStream[T any](data []T) Stream[T, T] {
-	source := make(chan T, len(data))
-	go func() {
-		defer close(source)
-		for _, item := range data {
-			source <- item
+// pipeline holds the state shared by all stages of a pipeline
+type pipeline struct {
+	mu  sync.Mutex
+	err error
+	// ended is set once the terminal operation has read the end of the
+	// stream, as opposed to stopping early
+	ended bool
+	// timing, if set, holds the stamps of WithTiming
+	timing *stamps
+}
+
+// fail records the first error raised by any stage of the pipeline
+func (p *pipeline) fail(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// Err returns the first error raised by the pipeline, if any
+func (p *pipeline) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// end records that the terminal operation read the end of the stream
+func (p *pipeline) end() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ended = true
+}
+
+// reachedEnd reports whether the terminal operation read the end of the
+// stream
+func (p *pipeline) reachedEnd() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ended
+}
+
+// signal is a channel that is closed at most once. Firing a signal also
+// fires every signal it is linked to upstream.
+type signal struct {
+	once sync.Once
+	ch   chan struct{}
+	up   []*signal
+
+	// parent, if set, is released rather than fired, see share
+	parent *signal
+	mu     sync.Mutex
+	shares []*signal
+	active int
+}
+
+func newSignal(up ...*signal) *signal {
+	return &signal{ch: make(chan struct{}), up: up}
+}
+
+// fire closes the signal and propagates it upstream, and down to the
+// signals it has shared
+func (s *signal) fire() {
+	first := false
+	s.once.Do(func() {
+		first = true
+		close(s.ch)
+		for _, u := range s.up {
+			u.fire()
 		}
-	}()
-	return &stream[T, T]{source: source, workers: 1}
+	})
+	if !first {
+		return
+	}
+	s.mu.Lock()
+	shares := s.shares
+	s.mu.Unlock()
+	for _, c := range shares {
+		c.fire()
+	}
+	if s.parent != nil {
+		s.parent.release()
+	}
 }
 
-// NewChanStream creates a new stream from a channel
-func NewChanStream[T any](ch <-chan T) Stream[T, T] {
-	source := make(chan T, 1)
+// share returns a signal for one of several consumers of the same
+// producers. s fires once every signal it has shared has fired, and firing
+// s fires all of them.
+func (s *signal) share() *signal {
+	c := &signal{ch: make(chan struct{}), parent: s}
+	s.mu.Lock()
+	s.shares = append(s.shares, c)
+	s.active++
+	s.mu.Unlock()
+	select {
+	case <-s.ch:
+		// s fired before c was added, so c missed it
+		c.fire()
+	default:
+	}
+	return c
+}
+
+// release fires s when the last of its shares is done with it
+func (s *signal) release() {
+	s.mu.Lock()
+	s.active--
+	last := s.active == 0
+	s.mu.Unlock()
+	if last {
+		s.fire()
+	}
+}
+
+// done returns a channel that is closed once the signal fires
+func (s *signal) done() <-chan struct{} { return s.ch }
+
+// newSource creates the head of a new pipeline reading from source
+func newSource[T any](source <-chan T) *stream[T, T] {
+	return &stream[T, T]{
+		source:  source,
+		workers: 1,
+		state:   &pipeline{},
+		quit:    newSignal(),
+	}
+}
+
+// newIterSource creates the head of a new pipeline pulling from next. No
+// goroutine is started until a push-based stage asks for the channel.
+func newIterSource[T any](next func() (T, bool), size int) *stream[T, T] {
+	return &stream[T, T]{
+		next:    next,
+		size:    size,
+		workers: 1,
+		state:   &pipeline{},
+		quit:    newSignal(),
+	}
+}
+
+// derive creates a stage reading from out that shares s's pipeline
+func derive[T any, R any, U any](s *stream[T, R], out <-chan U) *stream[U, U] {
+	return &stream[U, U]{
+		source:  out,
+		workers: s.workers,
+		lazy:    s.lazy,
+		pool:    s.pool,
+		profile: s.profile,
+		trace:   s.trace,
+		ordered: s.ordered,
+		buffer:  s.buffer,
+		state:   s.state,
+		quit:    s.quit,
+	}
+}
+
+// forward creates a stage of the same type reading from out
+func (s *stream[T, R]) forward(out <-chan T) *stream[T, R] {
+	return &stream[T, R]{
+		source:  out,
+		workers: s.workers,
+		lazy:    s.lazy,
+		pool:    s.pool,
+		profile: s.profile,
+		trace:   s.trace,
+		ordered: s.ordered,
+		buffer:  s.buffer,
+		state:   s.state,
+		quit:    s.quit,
+	}
+}
+
+// pulling reports whether the next stage should be evaluated on demand
+// rather than by a goroutine
+func (s *stream[T, R]) pulling() bool {
+	return s.lazy && s.workers <= 1
+}
+
+// iter returns a function pulling the next element of the stream
+func (s *stream[T, R]) iter() func() (T, bool) {
+	if s.source == nil && s.next != nil {
+		return s.next
+	}
+	source := s.channel()
+	return func() (T, bool) {
+		return recv(source, s.quit)
+	}
+}
+
+// channel returns the channel feeding the stream, starting the goroutine
+// that drains an iterator-backed source if necessary
+func (s *stream[T, R]) channel() <-chan T {
+	if s.source != nil || s.next == nil {
+		return s.source
+	}
+	source := make(chan T, s.size)
+	next := s.next
+	s.source, s.next = source, nil
 	go func() {
 		defer close(source)
-		for item := range ch {
-			source <- item
+		for {
+			item, ok := next()
+			if !ok || !send(source, item, s.quit) {
+				return
+			}
 		}
 	}()
-	return &stream[T, T]{source: source, workers: 1}
+	return source
+}
+
+// asStream returns the implementation behind a Stream
+func asStream[T any](s Stream[T, T]) *stream[T, T] {
+	return s.(*stream[T, T])
+}
+
+// fanOut runs fn on s.workers goroutines and waits for all of them to
+// return. The goroutines come from the stream's worker pool if it has one.
+// stage names the operator in the pprof labels set by WithPprofLabels.
+func (s *stream[T, R]) fanOut(stage string, fn func(worker int)) {
+	if s.profile {
+		fn = labelled(stage, fn)
+	}
+	if s.workers <= 1 {
+		fn(0)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(s.workers)
+	for i := 0; i < s.workers; i++ {
+		worker := i
+		task := func() {
+			defer wg.Done()
+			fn(worker)
+		}
+		if s.pool != nil {
+			s.pool.run(task)
+		} else {
+			go task()
+		}
+	}
+	wg.Wait()
+}
+
+// recoverPanic turns a panic of a user function in stage into a pipeline
+// error. It must be deferred directly by the goroutine calling the function.
+func (s *stream[T, R]) recoverPanic(stage string) {
+	if r := recover(); r != nil {
+		s.abort(panicError(stage, r))
+	}
+}
+
+// recoverPull is recoverPanic for the next function of a lazy stage. It
+// also clears ok, so that the element being processed is not emitted.
+func (s *stream[T, R]) recoverPull(stage string, ok *bool) {
+	if r := recover(); r != nil {
+		s.abort(panicError(stage, r))
+		*ok = false
+	}
+}
+
+// panicError wraps the value recovered from a panic in stage with ErrPanic
+func panicError(stage string, r any) error {
+	if err, ok := r.(error); ok {
+		return fmt.Errorf("%w in %s: %w", ErrPanic, stage, err)
+	}
+	return fmt.Errorf("%w in %s: %v", ErrPanic, stage, r)
+}
+
+// abort fails the pipeline with err and stops its producers
+func (s *stream[T, R]) abort(err error) {
+	s.state.fail(err)
+	s.quit.fire()
+}
+
+// send delivers item on out unless quit fires first
+func send[T any](out chan<- T, item T, quit *signal) bool {
+	select {
+	case out <- item:
+		return true
+	case <-quit.done():
+		return false
+	}
+}
+
+// recv takes the next element from source, reporting false once source is
+// closed or quit fires, so that a stage waiting on an idle source still
+// stops along with the pipeline
+func recv[T any](source <-chan T, quit *signal) (T, bool) {
+	select {
+	case item, ok := <-source:
+		return item, ok
+	case <-quit.done():
+		var zero T
+		return zero, false
+	}
+}
+
+// receive ranges over source with recv
+func receive[T any](source <-chan T, quit *signal) iter.Seq[T] {
+	return func(yield func(T) bool) {
+		for item, ok := recv(source, quit); ok; item, ok = recv(source, quit) {
+			if !yield(item) {
+				return
+			}
+		}
+	}
+}
+
+// NewSliceStream creates a new stream from a slice
+func NewSliceStream[T any](data []T) Stream[T, T] {
+	i := 0
+	return newIterSource(func() (T, bool) {
+		if i >= len(data) {
+			var zero T
+			return zero, false
+		}
+		i++
+		return data[i-1], true
+	}, len(data))
+}
+
+// NewChanStream creates a new stream from a channel. The stream reads ch
+// directly, without a goroutine copying it, and ends once ch is closed.
+func NewChanStream[T any](ch <-chan T) Stream[T, T] {
+	return newSource(ch)
 }
 
 // Map implements Stream.Map
 func (s *stream[T, R]) Map(fn func(T) R) Stream[R, R] {
-	out := make(chan R, s.workers)
+	if s.state.timing != nil {
+		fn = carryStamps(s.state.timing, fn)
+	}
+	if s.pulling() {
+		next := s.iter()
+		return &stream[R, R]{
+			next: func() (result R, ok bool) {
+				defer s.recoverPull("Map", &ok)
+				item, ok := next()
+				if !ok {
+					return result, false
+				}
+				return fn(item), true
+			},
+			workers: s.workers,
+			lazy:    true,
+			pool:    s.pool,
+			profile: s.profile,
+			trace:   s.trace,
+			ordered: s.ordered,
+			buffer:  s.buffer,
+			state:   s.state,
+			quit:    s.quit,
+		}
+	}
+
+	source := s.channel()
+	out := make(chan R, s.capacity())
 
 	go func() {
 		defer close(out)
+		defer s.recoverPanic("Map")
 
 		if s.workers == 1 {
 			// Sequential processing
-			for item := range s.source {
-				out <- fn(item)
+			for item := range receive(source, s.quit) {
+				if !send(out, fn(item), s.quit) {
+					return
+				}
 			}
 			return
 		}
 
+		if s.ordered {
+			fanOutOrdered(s, "Map", source, func(worker int, item T) R {
+				result := fn(item)
+				s.trace.record("Map", worker, item, result)
+				return result
+			}, func(result R) bool {
+				return send(out, result, s.quit)
+			})
+			return
+		}
+
 		// Parallel processing
-		var wg sync.WaitGroup
-		for i := 0; i < s.workers; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for item := range s.source {
-					out <- fn(item)
+		s.fanOut("Map", func(worker int) {
+			defer s.recoverPanic("Map")
+			for item := range receive(source, s.quit) {
+				result := fn(item)
+				s.trace.record("Map", worker, item, result)
+				if !send(out, result, s.quit) {
+					return
 				}
-			}()
-		}
-		wg.Wait()
+			}
+		})
 	}()
 
-	return &stream[R, R]{source: out, workers: s.workers}
+	return derive(s, out)
 }
 
 // Filter implements Stream.Filter
 func (s *stream[T, R]) Filter(fn func(T) bool) Stream[T, R] {
-	out := make(chan T, s.workers)
+	if s.pulling() {
+		next := s.iter()
+		return &stream[T, R]{
+			next: func() (item T, ok bool) {
+				defer s.recoverPull("Filter", &ok)
+				for {
+					item, ok = next()
+					if !ok || fn(item) {
+						return item, ok
+					}
+				}
+			},
+			workers: s.workers,
+			lazy:    true,
+			pool:    s.pool,
+			profile: s.profile,
+			trace:   s.trace,
+			ordered: s.ordered,
+			buffer:  s.buffer,
+			state:   s.state,
+			quit:    s.quit,
+		}
+	}
+
+	source := s.channel()
+	out := make(chan T, s.capacity())
 
 	go func() {
 		defer close(out)
+		defer s.recoverPanic("Filter")
 
 		if s.workers == 1 {
 			// Sequential processing
-			for item := range s.source {
-				if fn(item) {
-					out <- item
+			for item := range receive(source, s.quit) {
+				if fn(item) && !send(out, item, s.quit) {
+					return
 				}
 			}
 			return
 		}
 
-		// Parallel processing
-		var wg sync.WaitGroup
-		for i := 0; i < s.workers; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for item := range s.source {
-					if fn(item) {
-						out <- item
-					}
+		if s.ordered {
+			fanOutOrdered(s, "Filter", source, func(worker int, item T) Pair[T, bool] {
+				keep := fn(item)
+				if keep {
+					s.trace.record("Filter", worker, item, item)
 				}
-			}()
+				return Pair[T, bool]{item, keep}
+			}, func(p Pair[T, bool]) bool {
+				return !p.Second || send(out, p.First, s.quit)
+			})
+			return
 		}
-		wg.Wait()
+
+		// Parallel processing
+		s.fanOut("Filter", func(worker int) {
+			defer s.recoverPanic("Filter")
+			for item := range receive(source, s.quit) {
+				if !fn(item) {
+					continue
+				}
+				s.trace.record("Filter", worker, item, item)
+				if !send(out, item, s.quit) {
+					return
+				}
+			}
+		})
 	}()
 
-	return &stream[T, R]{source: out, workers: s.workers}
+	return s.forward(out)
 }
 
 // Reduce implements Stream.Reduce
 func (s *stream[T, R]) Reduce(fn func(T, T) T) (T, error) {
+	return s.ReduceCtx(context.Background(), fn)
+}
+
+// ReduceCtx implements Stream.ReduceCtx. Once ctx is done it returns the
+// partial result along with a wrapped ErrCancelled or ErrDeadline.
+func (s *stream[T, R]) ReduceCtx(ctx context.Context, fn func(T, T) T) (T, error) {
 	var result T
-	var first bool = true
+	first := true
 
-	for item := range s.source {
+	err := s.drain(ctx, func(item T) bool {
 		if first {
 			result = item
 			first = false
-			continue
+		} else {
+			result = fn(result, item)
 		}
-		result = fn(result, item)
+		return true
+	})
+	if err != nil {
+		return result, err
+	}
+	if first {
+		return result, ErrEmptyStream
 	}
+	return result, nil
+}
+
+// ReduceCtxFold implements Stream.ReduceCtxFold. ctx stops the wait for the
+// next element and is passed into fn, so a heavy combiner can also give up
+// halfway by returning; an error returned by fn after ctx is done is
+// reported as the context error. The first error stops the reduction.
+func (s *stream[T, R]) ReduceCtxFold(ctx context.Context, fn func(ctx context.Context, a, b T) (T, error)) (T, error) {
+	var result T
+	first := true
 
+	var fnErr error
+	err := s.drain(ctx, func(item T) bool {
+		if first {
+			result = item
+			first = false
+			return true
+		}
+		combined, err := fn(ctx, result, item)
+		if err != nil {
+			fnErr = err
+			return false
+		}
+		result = combined
+		return true
+	})
+	if fnErr != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return result, contextError(ctxErr)
+		}
+		return result, fnErr
+	}
+	if err != nil {
+		return result, err
+	}
 	if first {
 		return result, ErrEmptyStream
 	}
@@ -148,63 +751,267 @@ func (s *stream[T, R]) Reduce(fn func(T, T) T) (T, error) {
 
 // ForEach implements Stream.ForEach
 func (s *stream[T, R]) ForEach(fn func(T)) error {
-	for item := range s.source {
+	defer s.quit.fire()
+
+	next := s.iter()
+	if err := s.each(next, fn); err != nil {
+		return err
+	}
+	return s.state.Err()
+}
+
+// ForEachCtx implements Stream.ForEachCtx. The error returned by fn is
+// returned as is; a panic in fn is reported like one in ForEach.
+func (s *stream[T, R]) ForEachCtx(ctx context.Context, fn func(T) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError("ForEachCtx", r)
+			s.abort(err)
+		}
+	}()
+
+	var fnErr error
+	err = s.drain(ctx, func(item T) bool {
+		if fnErr = fn(item); fnErr != nil {
+			// Recorded so that stages such as WithAck see the failure
+			s.state.fail(fnErr)
+			return false
+		}
+		return true
+	})
+	if err == nil {
+		err = fnErr
+	}
+	return err
+}
+
+// each calls fn on every element returned by next, turning a panic of fn into
+// an error that also stops the producers
+func (s *stream[T, R]) each(next func() (T, bool), fn func(T)) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError("ForEach", r)
+			s.abort(err)
+		}
+	}()
+	for item, ok := next(); ok; item, ok = next() {
 		fn(item)
 	}
+	s.state.end()
 	return nil
 }
 
 // Collect implements Stream.Collect
 func (s *stream[T, R]) Collect(ctx context.Context) ([]T, error) {
 	var result []T
+	err := s.drain(ctx, func(item T) bool {
+		result = append(result, item)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// drain passes the elements to fn until it returns false, the stream ends or
+// ctx is done, and returns the pipeline or context error, if any. The
+// producers are released once it returns, for whatever reason.
+func (s *stream[T, R]) drain(ctx context.Context, fn func(T) bool) error {
+	if s.pulling() {
+		return s.pull(ctx, fn)
+	}
+	defer s.quit.fire()
 
+	source := s.channel()
 	for {
 		select {
-		case item, ok := <-s.source:
+		case item, ok := <-source:
 			if !ok {
-				return result, nil
+				s.state.end()
+				return s.state.Err()
 			}
-			result = append(result, item)
+			if !fn(item) {
+				return nil
+			}
+		case <-s.quit.done():
+			// Stopped upstream, by WithContext for instance
+			return s.state.Err()
 		case <-ctx.Done():
-			return nil, ctx.Err()
-		default:
-			// Add a small sleep to allow context cancellation to be detected
-			time.Sleep(1 * time.Millisecond)
+			return contextError(ctx.Err())
+		}
+	}
+}
+
+// pull is drain for a stream read on the caller's goroutine. ctx is only
+// checked between elements.
+func (s *stream[T, R]) pull(ctx context.Context, fn func(T) bool) error {
+	defer s.quit.fire()
+
+	next := s.iter()
+	for item, ok := next(); ok; item, ok = next() {
+		if err := ctx.Err(); err != nil {
+			return contextError(err)
+		}
+		if err := s.state.Err(); err != nil {
+			return err
+		}
+		if !fn(item) {
+			return nil
 		}
 	}
+	s.state.end()
+	return s.state.Err()
 }
 
-// Parallel implements Stream.Parallel
+// Parallel implements Stream.Parallel. The new stream shares its source with
+// s, so if both are consumed each of them sees part of the elements.
 func (s *stream[T, R]) Parallel(workers int) Stream[T, R] {
+	return s.parallel(workers, false)
+}
+
+// OrderedParallel implements Stream.OrderedParallel. The workers still run
+// concurrently, but a result that is ready before those of earlier elements
+// is held back until they have been emitted. A slow element therefore stalls
+// the output, and the results buffered behind it grow with the number of
+// elements processed in the meantime.
+func (s *stream[T, R]) OrderedParallel(workers int) Stream[T, R] {
+	return s.parallel(workers, true)
+}
+
+// parallel returns a copy of s with the given worker count and ordering
+func (s *stream[T, R]) parallel(workers int, ordered bool) *stream[T, R] {
 	if workers <= 0 {
 		workers = 1
 	}
-	s.workers = workers
-	return s
+	c := s.clone()
+	c.workers = workers
+	c.ordered = ordered && workers > 1
+	return c
+}
+
+// clone returns a copy of s that may be consumed alongside s and any other
+// copy, each of them receiving a share of the elements. An iterator-backed
+// source is guarded so that the copies pull from it in turn, and the
+// producers of source stop once every copy is done with them.
+func (s *stream[T, R]) clone() *stream[T, R] {
+	if s.next != nil && !s.shared {
+		var mu sync.Mutex
+		next, ended := s.next, false
+		s.next = func() (T, bool) {
+			mu.Lock()
+			defer mu.Unlock()
+			if ended {
+				var zero T
+				return zero, false
+			}
+			item, ok := next()
+			ended = !ok
+			return item, ok
+		}
+		s.shared = true
+	}
+	c := *s
+	c.quit = s.quit.share()
+	return &c
+}
+
+// WithBuffer implements Stream.WithBuffer. Like Parallel it returns a copy,
+// leaving s unchanged. It panics if n is not positive.
+func (s *stream[T, R]) WithBuffer(n int) Stream[T, R] {
+	if n <= 0 {
+		panic("chain: WithBuffer size must be positive")
+	}
+	c := s.clone()
+	c.buffer = n
+	return c
+}
+
+// capacity returns the output channel capacity of Map and Filter stages
+func (s *stream[T, R]) capacity() int {
+	if s.buffer > 0 {
+		return s.buffer
+	}
+	return s.workers
+}
+
+// WithPprofLabels implements Stream.WithPprofLabels. Like Parallel it
+// returns a copy, leaving s unchanged.
+func (s *stream[T, R]) WithPprofLabels() Stream[T, R] {
+	c := s.clone()
+	c.profile = true
+	return c
+}
+
+// labelled wraps fn to run under the pprof labels chain.stage and
+// chain.worker
+func labelled(stage string, fn func(worker int)) func(worker int) {
+	return func(worker int) {
+		labels := pprof.Labels("chain.stage", stage, "chain.worker", strconv.Itoa(worker))
+		pprof.Do(context.Background(), labels, func(context.Context) {
+			fn(worker)
+		})
+	}
+}
+
+// Lazy implements Stream.Lazy. Like Parallel it returns a copy, leaving s
+// unchanged.
+func (s *stream[T, R]) Lazy() Stream[T, R] {
+	c := s.clone()
+	c.lazy = true
+	return c
 }
 
 // Helper functions
 
 // Generator creates a stream from a generator function
 func Generator[T any](gen func() (T, bool)) Stream[T, T] {
-	source := make(chan T, 1)
-	go func() {
-		defer close(source)
-		for {
-			item, ok := gen()
-			if !ok {
-				return
-			}
-			source <- item
-		}
-	}()
-	return &stream[T, T]{source: source, workers: 1}
+	return newIterSource(gen, 1)
 }
 
 // Errors
-var ErrEmptyStream = Error("empty stream")
+var (
+	ErrEmptyStream = Error("empty stream")
+
+	// ErrInsufficientData is returned by CollectAtLeast when the stream
+	// produces fewer elements than required
+	ErrInsufficientData = Error("insufficient data")
+
+	// ErrDeadline and ErrCancelled wrap the context error returned when a
+	// terminal operation is interrupted by its context
+	ErrDeadline  = Error("stream deadline exceeded")
+	ErrCancelled = Error("stream cancelled")
+
+	// ErrNoMoreMessages is returned by the consume function of
+	// NewConsumerStream to end the stream cleanly
+	ErrNoMoreMessages = Error("no more messages")
+
+	// ErrDuplicateKey is wrapped by the error ToMapStrict returns when two
+	// elements share a key
+	ErrDuplicateKey = Error("duplicate key")
+
+	// ErrPanic is wrapped by the error reported when a user function given
+	// to Map, Filter, Peek, ForEach or ForEachCtx panics
+	ErrPanic = Error("panic in user function")
+
+	// ErrCircuitOpen is passed to the onReject callback of MapCircuitBreaker
+	// for the elements dropped while the breaker is open
+	ErrCircuitOpen = Error("circuit open")
+)
 
 // Error represents a stream error
 type Error string
 
 func (e Error) Error() string { return string(e) }
+
+// contextError wraps a context error with ErrDeadline or ErrCancelled while
+// still unwrapping to the original error
+func contextError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrDeadline, err)
+	case errors.Is(err, context.Canceled):
+		return fmt.Errorf("%w: %w", ErrCancelled, err)
+	}
+	return err
+}
@@ -0,0 +1,346 @@
+package chain
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// CollectStable gathers all elements of s and stable-sorts them by keyFn, so
+// that a parallel pipeline still produces deterministic output. Elements with
+// equal keys keep the order in which they were collected.
+func CollectStable[T any, K cmp.Ordered](ctx context.Context, s Stream[T, T], keyFn func(T) K) ([]T, error) {
+	result, err := s.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return keyFn(result[i]) < keyFn(result[j])
+	})
+	return result, nil
+}
+
+// Sorted emits the elements of s in ascending order. It is a blocking
+// operator: the whole stream is buffered and nothing is emitted until the
+// source ends, so it must not be used on an infinite generator.
+func Sorted[T cmp.Ordered](s Stream[T, T]) Stream[T, T] {
+	return SortedBy(s, cmp.Less[T])
+}
+
+// SortedBy emits the elements of s ordered by less, keeping the arrival order
+// of equal elements. Like Sorted, it buffers the whole stream before emitting
+// anything, so it must not be used on an infinite generator.
+func SortedBy[T any](s Stream[T, T], less func(a, b T) bool) Stream[T, T] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		var buf []T
+		for item := range receive(source, in.quit) {
+			buf = append(buf, item)
+		}
+		if in.state.Err() != nil {
+			return
+		}
+
+		sort.SliceStable(buf, func(i, j int) bool { return less(buf[i], buf[j]) })
+		for _, item := range buf {
+			if !send(out, item, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// Count implements Stream.Count. The elements are not kept.
+func (s *stream[T, R]) Count(ctx context.Context) (int, error) {
+	n := 0
+	err := s.drain(ctx, func(T) bool {
+		n++
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// First implements Stream.First
+func (s *stream[T, R]) First(ctx context.Context) (T, bool, error) {
+	var first T
+	found := false
+	err := s.drain(ctx, func(item T) bool {
+		first, found = item, true
+		return false
+	})
+	if err != nil {
+		var zero T
+		return zero, false, err
+	}
+	return first, found, nil
+}
+
+// Last implements Stream.Last
+func (s *stream[T, R]) Last(ctx context.Context) (T, bool, error) {
+	var last T
+	found := false
+	err := s.drain(ctx, func(item T) bool {
+		last, found = item, true
+		return true
+	})
+	if err != nil {
+		var zero T
+		return zero, false, err
+	}
+	return last, found, nil
+}
+
+// Find implements Stream.Find
+func (s *stream[T, R]) Find(ctx context.Context, fn func(T) bool) (T, bool, error) {
+	var match T
+	found := false
+	err := s.drain(ctx, func(item T) bool {
+		if fn(item) {
+			match, found = item, true
+		}
+		return !found
+	})
+	if err != nil {
+		var zero T
+		return zero, false, err
+	}
+	return match, found, nil
+}
+
+// Any implements Stream.Any. An empty stream has no matching element.
+func (s *stream[T, R]) Any(ctx context.Context, fn func(T) bool) (bool, error) {
+	found := false
+	err := s.drain(ctx, func(item T) bool {
+		found = fn(item)
+		return !found
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// All implements Stream.All. It holds for an empty stream.
+func (s *stream[T, R]) All(ctx context.Context, fn func(T) bool) (bool, error) {
+	found, err := s.Any(ctx, func(item T) bool { return !fn(item) })
+	if err != nil {
+		return false, err
+	}
+	return !found, nil
+}
+
+// None implements Stream.None. It holds for an empty stream.
+func (s *stream[T, R]) None(ctx context.Context, fn func(T) bool) (bool, error) {
+	found, err := s.Any(ctx, fn)
+	if err != nil {
+		return false, err
+	}
+	return !found, nil
+}
+
+// CollectPages implements Stream.CollectPages. Every page holds pageSize
+// elements except the last one, which may be partial.
+func (s *stream[T, R]) CollectPages(ctx context.Context, pageSize int) ([][]T, error) {
+	if pageSize <= 0 {
+		s.quit.fire()
+		return nil, fmt.Errorf("chain: page size must be positive, got %d", pageSize)
+	}
+	result, err := s.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pages := make([][]T, 0, (len(result)+pageSize-1)/pageSize)
+	for len(result) > pageSize {
+		pages = append(pages, result[:pageSize:pageSize])
+		result = result[pageSize:]
+	}
+	if len(result) > 0 {
+		pages = append(pages, result)
+	}
+	return pages, nil
+}
+
+// CollectAtLeast implements Stream.CollectAtLeast. The returned error wraps
+// ErrInsufficientData when fewer than min elements were produced.
+func (s *stream[T, R]) CollectAtLeast(ctx context.Context, min int) ([]T, error) {
+	result, err := s.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(result) < min {
+		return nil, fmt.Errorf("%w: got %d elements, need at least %d", ErrInsufficientData, len(result), min)
+	}
+	return result, nil
+}
+
+// CollectPoll implements Stream.CollectPoll. When no element is ready it
+// waits according to DefaultBackoff, growing the wait with each consecutive
+// empty poll, and concludes that the stream is done once maxEmptyPolls polls
+// in a row found nothing. An element arriving during a wait is taken at once
+// and resets the count. A closed source ends collection immediately.
+func (s *stream[T, R]) CollectPoll(ctx context.Context, maxEmptyPolls int) ([]T, error) {
+	defer s.quit.fire()
+
+	var result []T
+	source := s.channel()
+	for empty := 0; ; {
+		var (
+			item T
+			ok   bool
+		)
+		select {
+		case item, ok = <-source:
+		default:
+			if empty >= maxEmptyPolls {
+				return result, nil
+			}
+			timer := time.NewTimer(DefaultBackoff.Delay(empty))
+			select {
+			case item, ok = <-source:
+				timer.Stop()
+			case <-s.quit.done():
+				// Stopped upstream; handled like the end of the stream
+				timer.Stop()
+			case <-timer.C:
+				empty++
+				continue
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, contextError(ctx.Err())
+			}
+		}
+		if !ok {
+			if err := s.state.Err(); err != nil {
+				return nil, err
+			}
+			return result, nil
+		}
+		result = append(result, item)
+		empty = 0
+	}
+}
+
+// TimedElement is an element collected by CollectTimed
+type TimedElement[T any] struct {
+	Value T
+	// Latency is the time Value took from WithTiming to the terminal, or
+	// zero if it carries no stamp
+	Latency time.Duration
+}
+
+// CollectTimed implements Stream.CollectTimed. Each element is timed from the
+// stamp WithTiming gave it upstream to the moment it is collected, so under
+// Parallel a slow element stands out even when the others overtake it.
+// Elements without a stamp, because there is no WithTiming upstream or a
+// stage replaced them, report a zero latency.
+func (s *stream[T, R]) CollectTimed(ctx context.Context) ([]TimedElement[T], error) {
+	timing := s.state.timing
+	var result []TimedElement[T]
+	err := s.drain(ctx, func(item T) bool {
+		e := TimedElement[T]{Value: item}
+		if at, ok := timing.take(item); ok {
+			e.Latency = time.Since(at)
+		}
+		result = append(result, e)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Snapshot implements Stream.Snapshot. The replay stream reads its own copy
+// of the elements, so the returned slice may be modified freely.
+func (s *stream[T, R]) Snapshot(ctx context.Context) ([]T, Stream[T, T], error) {
+	result, err := s.Collect(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	replay := make([]T, len(result))
+	copy(replay, result)
+	return result, NewSliceStream(replay), nil
+}
+
+// GroupBy drains s and groups its elements by the key returned by keyFn,
+// keeping the encounter order within each group. Elements are grouped as they
+// arrive, without collecting them first. It stops with a wrapped ErrCancelled
+// or ErrDeadline once ctx is done.
+func GroupBy[T any, K comparable](ctx context.Context, s Stream[T, T], keyFn func(T) K) (map[K][]T, error) {
+	groups := make(map[K][]T)
+	err := asStream(s).drain(ctx, func(item T) bool {
+		k := keyFn(item)
+		groups[k] = append(groups[k], item)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// ToMap drains s into a map from keyFn to valFn of each element. When
+// several elements share a key, the last one wins. It stops with a wrapped
+// ErrCancelled or ErrDeadline once ctx is done.
+func ToMap[T any, K comparable, V any](ctx context.Context, s Stream[T, T], keyFn func(T) K, valFn func(T) V) (map[K]V, error) {
+	return toMap(ctx, s, keyFn, valFn, false)
+}
+
+// ToMapStrict is like ToMap, but fails with an error wrapping
+// ErrDuplicateKey as soon as a key repeats
+func ToMapStrict[T any, K comparable, V any](ctx context.Context, s Stream[T, T], keyFn func(T) K, valFn func(T) V) (map[K]V, error) {
+	return toMap(ctx, s, keyFn, valFn, true)
+}
+
+func toMap[T any, K comparable, V any](ctx context.Context, s Stream[T, T], keyFn func(T) K, valFn func(T) V, strict bool) (map[K]V, error) {
+	m := make(map[K]V)
+	var dupErr error
+	err := asStream(s).drain(ctx, func(item T) bool {
+		k := keyFn(item)
+		if _, ok := m[k]; ok && strict {
+			dupErr = fmt.Errorf("%w: %v", ErrDuplicateKey, k)
+			return false
+		}
+		m[k] = valFn(item)
+		return true
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// CollectLatestByKey drains s and keeps, for each key returned by keyFn, the
+// latest element, as decided by newer(a, b) reporting whether a is newer than
+// b. Of two elements that are equally new the first one collected is kept.
+// Only the latest element of each key is held in memory.
+func CollectLatestByKey[T any, K comparable](ctx context.Context, s Stream[T, T], keyFn func(T) K, newer func(a, b T) bool) (map[K]T, error) {
+	latest := make(map[K]T)
+	err := asStream(s).drain(ctx, func(item T) bool {
+		k := keyFn(item)
+		if current, ok := latest[k]; !ok || newer(item, current) {
+			latest[k] = item
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return latest, nil
+}
@@ -0,0 +1,474 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCollectStable(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = 99 - i
+	}
+
+	for run := 0; run < 5; run++ {
+		result, err := CollectStable(context.Background(),
+			NewSliceStream(input).Parallel(8).Map(func(x int) int {
+				return x * 2
+			}),
+			func(x int) int { return x },
+		)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if len(result) != len(input) {
+			t.Fatalf("expected length %d, got %d", len(input), len(result))
+		}
+		for i, v := range result {
+			if v != i*2 {
+				t.Fatalf("run %d at index %d: expected %d, got %d", run, i, i*2, v)
+			}
+		}
+	}
+}
+
+func TestCollectPages(t *testing.T) {
+	pages, err := NewSliceStream([]int{1, 2, 3, 4, 5, 6, 7}).CollectPages(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := [][]int{{1, 2, 3}, {4, 5, 6}, {7}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Errorf("expected %v, got %v", expected, pages)
+	}
+
+	pages, err = NewSliceStream([]int{}).CollectPages(context.Background(), 3)
+	if err != nil || len(pages) != 0 {
+		t.Errorf("expected no pages, got %v (err %v)", pages, err)
+	}
+
+	if _, err = NewSliceStream([]int{1}).CollectPages(context.Background(), 0); err == nil {
+		t.Error("expected an error for a zero page size")
+	}
+}
+
+func TestCollectAtLeast(t *testing.T) {
+	_, err := NewSliceStream([]int{1, 2}).CollectAtLeast(context.Background(), 3)
+	if !errors.Is(err, ErrInsufficientData) {
+		t.Errorf("expected ErrInsufficientData, got %v", err)
+	}
+
+	result, err := NewSliceStream([]int{1, 2}).CollectAtLeast(context.Background(), 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", result)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	data, replay, err := NewSliceStream([]int{3, 1, 2}).
+		Map(func(x int) int { return x * 10 }).
+		Snapshot(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Modifying the snapshot does not affect the replay
+	expected := append([]int(nil), data...)
+	data[0] = -1
+
+	replayed, err := replay.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(replayed, expected) || !reflect.DeepEqual(expected, []int{30, 10, 20}) {
+		t.Errorf("expected snapshot and replay to match %v, got %v", expected, replayed)
+	}
+}
+
+func TestCollectPoll(t *testing.T) {
+	ch := make(chan int)
+	defer close(ch)
+	go func() {
+		ch <- 1
+		ch <- 2
+		// A gap longer than the first few polls, after which the source
+		// resumes but is never closed
+		time.Sleep(30 * time.Millisecond)
+		ch <- 3
+	}()
+
+	result, err := NewChanStream(ch).CollectPoll(context.Background(), 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", result)
+	}
+
+	result, err = NewSliceStream([]int{1, 2}).CollectPoll(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", result)
+	}
+}
+
+func TestCollectTimed(t *testing.T) {
+	result, err := NewSliceStream([]int{1, 2, 3, 4, 5}).WithTiming().Parallel(4).Map(func(x int) int {
+		if x == 3 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return x * 10
+	}).CollectTimed(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 5 {
+		t.Fatalf("expected 5 elements, got %d", len(result))
+	}
+
+	// The other elements overtake the slow one, which is collected last but
+	// still stands out, as it is timed from when it was emitted
+	slow := result[len(result)-1]
+	if slow.Value != 30 {
+		t.Fatalf("expected 30 to be collected last, got %v", result)
+	}
+	if slow.Latency < 50*time.Millisecond {
+		t.Errorf("expected 30 to take at least 50ms, got %v", slow.Latency)
+	}
+	for _, e := range result[:len(result)-1] {
+		if e.Latency <= 0 || e.Latency >= slow.Latency {
+			t.Errorf("expected element %d (%v) to be faster than 30 (%v)", e.Value, e.Latency, slow.Latency)
+		}
+	}
+
+	// Without WithTiming nothing is stamped
+	result, err = NewSliceStream([]int{1, 2}).CollectTimed(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, e := range result {
+		if e.Latency != 0 {
+			t.Errorf("expected no latency for %d without WithTiming, got %v", e.Value, e.Latency)
+		}
+	}
+}
+
+func TestCollectLatestByKey(t *testing.T) {
+	type record struct {
+		ID      string
+		Version int
+		Value   string
+	}
+	records := []record{
+		{"a", 1, "a1"},
+		{"b", 2, "b2"},
+		{"a", 3, "a3"},
+		{"b", 1, "b1"},
+		{"c", 1, "c1"},
+		{"a", 2, "a2"},
+	}
+
+	latest, err := CollectLatestByKey(context.Background(), NewSliceStream(records),
+		func(r record) string { return r.ID },
+		func(a, b record) bool { return a.Version > b.Version })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]record{
+		"a": {"a", 3, "a3"},
+		"b": {"b", 2, "b2"},
+		"c": {"c", 1, "c1"},
+	}
+	if !reflect.DeepEqual(latest, expected) {
+		t.Errorf("expected %v, got %v", expected, latest)
+	}
+}
+
+func TestCount(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i + 1
+	}
+
+	n, err := NewSliceStream(input).Filter(func(x int) bool { return x%2 == 0 }).Count(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 50 {
+		t.Errorf("expected 50, got %d", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := NewSliceStream(input).Count(ctx); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestFirstLast(t *testing.T) {
+	tests := []struct {
+		input       []int
+		first, last int
+		found       bool
+	}{
+		{nil, 0, 0, false},
+		{[]int{7}, 7, 7, true},
+		{[]int{1, 2, 3}, 1, 3, true},
+	}
+	for _, tt := range tests {
+		first, found, err := NewSliceStream(tt.input).First(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if first != tt.first || found != tt.found {
+			t.Errorf("First of %v: expected (%d, %v), got (%d, %v)", tt.input, tt.first, tt.found, first, found)
+		}
+
+		last, found, err := NewSliceStream(tt.input).Last(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if last != tt.last || found != tt.found {
+			t.Errorf("Last of %v: expected (%d, %v), got (%d, %v)", tt.input, tt.last, tt.found, last, found)
+		}
+	}
+}
+
+func TestFirstShortCircuit(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var calls atomic.Int32
+	gen := Generator(func() (int, bool) {
+		return int(calls.Add(1)), true
+	})
+
+	first, found, err := gen.Filter(func(x int) bool { return x%5 == 0 }).First(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !found || first != 5 {
+		t.Errorf("expected (5, true), got (%d, %v)", first, found)
+	}
+
+	// The generator may have run ahead to fill the buffers between the
+	// stages, but once its goroutine has exited it is no longer called
+	waitForGoroutines(t, before)
+	if stopped := calls.Load(); stopped > 20 {
+		t.Errorf("expected the generator to stop shortly after 5, got %d calls", stopped)
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	parity := func(x int) string {
+		if x%2 == 0 {
+			return "even"
+		}
+		return "odd"
+	}
+
+	groups, err := GroupBy(context.Background(), NewSliceStream([]int{1, 2, 3, 4, 5, 6, 7}), parity)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]int{"even": {2, 4, 6}, "odd": {1, 3, 5, 7}}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected %v, got %v", expected, groups)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	gen := Generator(func() (int, bool) { return 1, true })
+	if _, err := GroupBy(ctx, gen, parity); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestSorted(t *testing.T) {
+	result, err := Sorted(NewSliceStream([]int{5, 3, 8, 1, 9, 2}).Filter(func(x int) bool { return x != 8 })).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 5, 9}) {
+		t.Errorf("expected [1 2 3 5 9], got %v", result)
+	}
+
+	words, err := Sorted(NewSliceStream([]string{"pear", "apple", "fig"})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(words, []string{"apple", "fig", "pear"}) {
+		t.Errorf("expected [apple fig pear], got %v", words)
+	}
+}
+
+func TestSortedBy(t *testing.T) {
+	users := []User{{Age: 30, Score: 95}, {Age: 22, Score: 70}, {Age: 35, Score: 85}, {Age: 28, Score: 70}}
+	result, err := SortedBy(NewSliceStream(users), func(a, b User) bool { return a.Score < b.Score }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Users with equal scores keep their order
+	expected := []User{{Age: 22, Score: 70}, {Age: 28, Score: 70}, {Age: 35, Score: 85}, {Age: 30, Score: 95}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	_, err = SortedBy(Cast[int](NewSliceStream([]any{2, "one"})), func(a, b int) bool { return a < b }).
+		Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected the source error to fail SortedBy")
+	}
+}
+
+func TestToMap(t *testing.T) {
+	users := []User{{Age: 30, Score: 95}, {Age: 22, Score: 70}, {Age: 35, Score: 85}}
+	scores, err := ToMap(context.Background(), NewSliceStream(users),
+		func(u User) int { return u.Age }, func(u User) int { return u.Score })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[int]int{30: 95, 22: 70, 35: 85}
+	if !reflect.DeepEqual(scores, expected) {
+		t.Errorf("expected %v, got %v", expected, scores)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ToMap(ctx, Generator(func() (int, bool) { return 1, true }),
+		func(x int) int { return x }, func(x int) int { return x })
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestToMapDuplicateKeys(t *testing.T) {
+	users := []User{{Age: 30, Score: 95}, {Age: 22, Score: 70}, {Age: 30, Score: 60}}
+	age := func(u User) int { return u.Age }
+	score := func(u User) int { return u.Score }
+
+	scores, err := ToMap(context.Background(), NewSliceStream(users), age, score)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(scores, map[int]int{30: 60, 22: 70}) {
+		t.Errorf("expected the last score to win, got %v", scores)
+	}
+
+	_, err = ToMapStrict(context.Background(), NewSliceStream(users), age, score)
+	if !errors.Is(err, ErrDuplicateKey) {
+		t.Errorf("expected ErrDuplicateKey, got %v", err)
+	}
+
+	scores, err = ToMapStrict(context.Background(), NewSliceStream(users[:2]), age, score)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(scores, map[int]int{30: 95, 22: 70}) {
+		t.Errorf("expected {30: 95, 22: 70}, got %v", scores)
+	}
+}
+
+func TestAnyAllNone(t *testing.T) {
+	// upTo counts the elements pulled out of 1..n, which must stay close to
+	// the deciding element
+	upTo := func(n int, pulled *atomic.Int32) Stream[int, int] {
+		return Generator(func() (int, bool) {
+			i := int(pulled.Add(1))
+			return i, i <= n
+		})
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		run      func(Stream[int, int]) (bool, error)
+		expected bool
+	}{
+		{"Any", func(s Stream[int, int]) (bool, error) { return s.Any(ctx, func(x int) bool { return x == 10 }) }, true},
+		{"All", func(s Stream[int, int]) (bool, error) { return s.All(ctx, func(x int) bool { return x < 10 }) }, false},
+		{"None", func(s Stream[int, int]) (bool, error) { return s.None(ctx, func(x int) bool { return x%10 == 0 }) }, false},
+	}
+	for _, tt := range tests {
+		var pulled atomic.Int32
+		result, err := tt.run(upTo(1000, &pulled))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, result)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if n := pulled.Load(); n > 20 {
+			t.Errorf("%s: expected to stop shortly after 10, pulled %d elements", tt.name, n)
+		}
+	}
+
+	if ok, err := upTo(1000, new(atomic.Int32)).All(ctx, func(x int) bool { return x > 0 }); err != nil || !ok {
+		t.Errorf("All: expected (true, nil), got (%v, %v)", ok, err)
+	}
+	if ok, err := upTo(1000, new(atomic.Int32)).Any(ctx, func(x int) bool { return x > 1000 }); err != nil || ok {
+		t.Errorf("Any: expected (false, nil), got (%v, %v)", ok, err)
+	}
+	if ok, err := upTo(1000, new(atomic.Int32)).None(ctx, func(x int) bool { return x > 1000 }); err != nil || !ok {
+		t.Errorf("None: expected (true, nil), got (%v, %v)", ok, err)
+	}
+
+	// The answer is known without reaching the end of an infinite generator
+	found, err := Iterate(1, func(x int) int { return x + 1 }).Any(ctx, func(x int) bool { return x == 500 })
+	if err != nil || !found {
+		t.Errorf("expected Any to find 500 in an infinite stream, got (%v, %v)", found, err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	users := []User{{Age: 25, Score: 80}, {Age: 30, Score: 95}, {Age: 35, Score: 85}, {Age: 40, Score: 90}}
+	user, found, err := NewSliceStream(users).Find(context.Background(), func(u User) bool { return u.Age > 30 })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !found || user != (User{Age: 35, Score: 85}) {
+		t.Errorf("expected ({35 85}, true), got (%v, %v)", user, found)
+	}
+
+	_, found, err = NewSliceStream(users).Find(context.Background(), func(u User) bool { return u.Age > 50 })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if found {
+		t.Errorf("expected no match")
+	}
+
+	var calls atomic.Int32
+	gen := Generator(func() (User, bool) {
+		n := int(calls.Add(1))
+		return User{Age: 20 + n, Score: n}, true
+	})
+	user, found, err = gen.Find(context.Background(), func(u User) bool { return u.Age > 30 })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !found || user.Age != 31 {
+		t.Errorf("expected the user aged 31, got (%v, %v)", user, found)
+	}
+	time.Sleep(10 * time.Millisecond)
+	stopped := calls.Load()
+	time.Sleep(10 * time.Millisecond)
+	if calls.Load() != stopped || stopped > 20 {
+		t.Errorf("expected the generator to stop shortly after the match, got %d calls", calls.Load())
+	}
+}
@@ -0,0 +1,356 @@
+package chain
+
+import (
+	"cmp"
+	"container/heap"
+	"sync"
+)
+
+// Pair holds two related values, such as an element and what it was joined with
+type Pair[A any, B any] struct {
+	First  A
+	Second B
+}
+
+// join creates the head of a new pipeline fed by several input streams.
+// Stopping the joined stream stops all of its inputs.
+func join[T any, U any](out <-chan U, inputs []*stream[T, T]) *stream[U, U] {
+	quits := make([]*signal, len(inputs))
+	for i, in := range inputs {
+		quits[i] = in.quit
+	}
+	s := newSource[U](out)
+	s.quit = newSignal(quits...)
+	return s
+}
+
+// inherit records the error of an exhausted input stream in s's pipeline
+func (s *stream[T, R]) inherit(err error) {
+	if err != nil {
+		s.state.fail(err)
+	}
+}
+
+// mergeHead is the next pending element of one input of MergePriority
+type mergeHead[T any, K cmp.Ordered] struct {
+	item  T
+	key   K
+	input int
+}
+
+// mergeHeap orders heads by key, breaking ties by input position
+type mergeHeap[T any, K cmp.Ordered] []mergeHead[T, K]
+
+func (h mergeHeap[T, K]) Len() int { return len(h) }
+func (h mergeHeap[T, K]) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].input < h[j].input
+}
+func (h mergeHeap[T, K]) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap[T, K]) Push(x any)   { *h = append(*h, x.(mergeHead[T, K])) }
+func (h *mergeHeap[T, K]) Pop() any {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// MergePriority performs a k-way merge of streams that are each sorted by
+// keyFn, emitting at every step the available head with the smallest key.
+// The output is globally sorted by key as long as every input is; elements
+// with equal keys are taken from the earlier input first.
+func MergePriority[T any, K cmp.Ordered](keyFn func(T) K, streams ...Stream[T, T]) Stream[T, T] {
+	inputs := make([]*stream[T, T], len(streams))
+	sources := make([]<-chan T, len(streams))
+	for i, s := range streams {
+		inputs[i] = asStream(s)
+		sources[i] = inputs[i].channel()
+	}
+	out := make(chan T, 1)
+	merged := join(out, inputs)
+
+	go func() {
+		defer close(out)
+
+		// pull reads the next element of input i onto the heap
+		h := make(mergeHeap[T, K], 0, len(sources))
+		pull := func(i int) {
+			if item, ok := recv(sources[i], inputs[i].quit); ok {
+				heap.Push(&h, mergeHead[T, K]{item: item, key: keyFn(item), input: i})
+				return
+			}
+			merged.inherit(inputs[i].state.Err())
+		}
+
+		for i := range sources {
+			pull(i)
+		}
+		for h.Len() > 0 {
+			head := heap.Pop(&h).(mergeHead[T, K])
+			if !send(out, head.item, merged.quit) {
+				return
+			}
+			pull(head.input)
+		}
+	}()
+
+	return merged
+}
+
+// Merge drains all streams concurrently into one, in whatever order their
+// elements arrive. The merged stream ends once every input is exhausted; an
+// error in any input fails it.
+func Merge[T any](streams ...Stream[T, T]) Stream[T, T] {
+	inputs := make([]*stream[T, T], len(streams))
+	for i, s := range streams {
+		inputs[i] = asStream(s)
+	}
+	out := make(chan T, len(inputs))
+	merged := join(out, inputs)
+
+	var wg sync.WaitGroup
+	wg.Add(len(inputs))
+	for _, in := range inputs {
+		source := in.channel()
+		go func() {
+			defer wg.Done()
+			for item := range receive(source, in.quit) {
+				if !send(out, item, merged.quit) {
+					return
+				}
+			}
+			merged.inherit(in.state.Err())
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return merged
+}
+
+// Concat emits all elements of each stream in turn, draining one completely
+// before moving on to the next, so the output keeps the order of both the
+// streams and their elements. An error in any input fails the concatenated
+// stream, and the inputs after it are not read.
+func Concat[T any](streams ...Stream[T, T]) Stream[T, T] {
+	inputs := make([]*stream[T, T], len(streams))
+	for i, s := range streams {
+		inputs[i] = asStream(s)
+	}
+	out := make(chan T, 1)
+	concatenated := join(out, inputs)
+
+	go func() {
+		defer close(out)
+		for _, in := range inputs {
+			for item := range receive(in.channel(), in.quit) {
+				if !send(out, item, concatenated.quit) {
+					return
+				}
+			}
+			if err := in.state.Err(); err != nil {
+				concatenated.abort(err)
+				return
+			}
+		}
+	}()
+
+	return concatenated
+}
+
+// FlattenStreams concatenates a stream of streams, draining each inner stream
+// completely, in order, before moving on to the next one. An error in the
+// outer stream or any inner stream fails the flattened stream.
+func FlattenStreams[T any](s Stream[Stream[T, T], Stream[T, T]]) Stream[T, T] {
+	outer := asStream(s)
+	streams := outer.channel()
+	out := make(chan T, 1)
+	flat := join(out, []*stream[Stream[T, T], Stream[T, T]]{outer})
+
+	go func() {
+		defer close(out)
+		for sub := range streams {
+			inner := asStream(sub)
+			for item := range inner.channel() {
+				if !send(out, item, flat.quit) {
+					inner.quit.fire()
+					return
+				}
+			}
+			if err := inner.state.Err(); err != nil {
+				flat.abort(err)
+				return
+			}
+		}
+		flat.inherit(outer.state.Err())
+	}()
+
+	return flat
+}
+
+// teeBuffer is the number of elements by which one branch of Tee can run
+// ahead of the other
+const teeBuffer = 64
+
+// Tee splits s into two streams that each receive every element of s. It is
+// TeeBuffered with a buffer of 64 elements per branch.
+func Tee[T any](s Stream[T, T]) (Stream[T, T], Stream[T, T]) {
+	return TeeBuffered(s, teeBuffer)
+}
+
+// TeeBuffered splits s into two streams that each receive every element of s,
+// in the same order. Each branch buffers up to size elements, so one can run
+// that far ahead of the other. It panics if size is not positive.
+func TeeBuffered[T any](s Stream[T, T], size int) (Stream[T, T], Stream[T, T]) {
+	if size <= 0 {
+		panic("chain: TeeBuffered size must be positive")
+	}
+	in := asStream(s)
+	source := in.channel()
+	outs := [2]chan T{make(chan T, size), make(chan T, size)}
+	branches := [2]*stream[T, T]{newSource[T](outs[0]), newSource[T](outs[1])}
+	for _, b := range branches {
+		b.workers = in.workers
+		b.lazy = in.lazy
+		b.pool = in.pool
+	}
+
+	go func() {
+		defer func() {
+			for i, b := range branches {
+				b.inherit(in.state.Err())
+				close(outs[i])
+			}
+		}()
+
+		var done [2]bool
+		for item := range receive(source, in.quit) {
+			var pending [2]chan T
+			for i := range outs {
+				if !done[i] {
+					pending[i] = outs[i]
+				}
+			}
+			for pending[0] != nil || pending[1] != nil {
+				select {
+				case pending[0] <- item:
+					pending[0] = nil
+				case pending[1] <- item:
+					pending[1] = nil
+				case <-branches[0].quit.done():
+					pending[0], done[0] = nil, true
+				case <-branches[1].quit.done():
+					pending[1], done[1] = nil, true
+				}
+			}
+			if done[0] && done[1] {
+				in.quit.fire()
+				return
+			}
+		}
+	}()
+
+	return branches[0], branches[1]
+}
+
+// JoinMap left-joins every element against table using keyFn, emitting the
+// element paired with the matching value. For keys missing from table,
+// onMissing decides: it returns the value to pair the element with and true,
+// or false to drop the element. A nil onMissing drops unmatched elements.
+func JoinMap[T any, K comparable, V any](s Stream[T, T], keyFn func(T) K, table map[K]V, onMissing func(T) (V, bool)) Stream[Pair[T, V], Pair[T, V]] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan Pair[T, V], in.workers)
+
+	go func() {
+		defer close(out)
+		in.fanOut("JoinMap", func(int) {
+			for item := range receive(source, in.quit) {
+				v, ok := table[keyFn(item)]
+				if !ok && onMissing != nil {
+					v, ok = onMissing(item)
+				}
+				if ok && !send(out, Pair[T, V]{item, v}, in.quit) {
+					return
+				}
+			}
+		})
+	}()
+
+	return derive(in, out)
+}
+
+// Interleave strictly alternates between a and b, starting with a, and
+// continues with the rest of the other stream once one of them ends. Unlike a
+// concurrent merge the output order is fully determined by the inputs.
+func Interleave[T any](a, b Stream[T, T]) Stream[T, T] {
+	inputs := []*stream[T, T]{asStream(a), asStream(b)}
+	sources := []<-chan T{inputs[0].channel(), inputs[1].channel()}
+	out := make(chan T, 1)
+	interleaved := join(out, inputs)
+
+	go func() {
+		defer close(out)
+
+		live := len(sources)
+		for turn := 0; live > 0; turn = (turn + 1) % len(sources) {
+			if sources[turn] == nil {
+				continue
+			}
+			item, ok := recv(sources[turn], inputs[turn].quit)
+			if !ok {
+				interleaved.inherit(inputs[turn].state.Err())
+				sources[turn] = nil
+				live--
+				continue
+			}
+			if !send(out, item, interleaved.quit) {
+				return
+			}
+		}
+	}()
+
+	return interleaved
+}
+
+// Zip pairs up the elements of a and b in order, emitting fn applied to the
+// n-th element of each. It stops as soon as either input ends, and then stops
+// the other one too, so inputs of unequal length, even an endless generator,
+// are fine. An error in either input fails the zipped stream.
+func Zip[A any, B any, C any](a Stream[A, A], b Stream[B, B], fn func(A, B) C) Stream[C, C] {
+	left, right := asStream(a), asStream(b)
+	lefts, rights := left.channel(), right.channel()
+	out := make(chan C, 1)
+	zipped := newSource[C](out)
+	zipped.quit = newSignal(left.quit, right.quit)
+
+	go func() {
+		defer close(out)
+		defer func() {
+			left.quit.fire()
+			right.quit.fire()
+		}()
+
+		for {
+			x, ok := recv(lefts, left.quit)
+			if !ok {
+				zipped.inherit(left.state.Err())
+				return
+			}
+			y, ok := recv(rights, right.quit)
+			if !ok {
+				zipped.inherit(right.state.Err())
+				return
+			}
+			if !send(out, fn(x, y), zipped.quit) {
+				return
+			}
+		}
+	}()
+
+	return zipped
+}
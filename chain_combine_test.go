@@ -0,0 +1,342 @@
+package chain
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMergePriority(t *testing.T) {
+	type event struct {
+		At   int
+		Name string
+	}
+	a := NewSliceStream([]event{{1, "a1"}, {4, "a4"}, {7, "a7"}})
+	b := NewSliceStream([]event{{2, "b2"}, {5, "b5"}})
+	c := NewSliceStream([]event{{3, "c3"}, {4, "c4"}, {8, "c8"}, {9, "c9"}})
+
+	result, err := MergePriority(func(e event) int { return e.At }, a, b, c).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names []string
+	for _, e := range result {
+		names = append(names, e.Name)
+	}
+	expected := []string{"a1", "b2", "c3", "a4", "c4", "b5", "a7", "c8", "c9"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v, got %v", expected, names)
+	}
+}
+
+func TestFlattenStreams(t *testing.T) {
+	page := 0
+	pages := Generator(func() (Stream[int, int], bool) {
+		page++
+		if page > 3 {
+			return nil, false
+		}
+		start := (page - 1) * 3
+		return NewSliceStream([]int{start + 1, start + 2, start + 3}), true
+	})
+
+	result, err := FlattenStreams(pages).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []int{1, 2, 3, 4, 5, 6, 7, 8, 9}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestFlattenStreamsError(t *testing.T) {
+	inner := []Stream[int, int]{
+		NewSliceStream([]int{1}),
+		Cast[int](NewSliceStream([]any{"oops"})),
+	}
+	_, err := FlattenStreams(NewSliceStream(inner)).Collect(context.Background())
+	if err == nil {
+		t.Error("expected the inner stream error to be surfaced")
+	}
+}
+
+func TestTeeOrdering(t *testing.T) {
+	input := make([]int, 200)
+	for i := range input {
+		input[i] = i
+	}
+
+	a, b := Tee(NewSliceStream(input).Map(func(x int) int { return x * 2 }))
+
+	results := make(chan []int, 2)
+	for _, branch := range []Stream[int, int]{a, b} {
+		go func(s Stream[int, int]) {
+			result, err := s.Collect(context.Background())
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			results <- result
+		}(branch)
+	}
+	first, second := <-results, <-results
+
+	expected := make([]int, len(input))
+	for i, v := range input {
+		expected[i] = v * 2
+	}
+	if !reflect.DeepEqual(first, expected) {
+		t.Errorf("first branch out of order: %v", first)
+	}
+	if !reflect.DeepEqual(second, expected) {
+		t.Errorf("second branch out of order: %v", second)
+	}
+}
+
+func TestTeeParallelSameSequence(t *testing.T) {
+	input := make([]int, 200)
+	for i := range input {
+		input[i] = i
+	}
+
+	a, b := Tee(NewSliceStream(input).Parallel(4).Map(func(x int) int { return x + 1 }))
+
+	results := make(chan []int, 2)
+	for _, branch := range []Stream[int, int]{a, b} {
+		go func(s Stream[int, int]) {
+			result, _ := s.Collect(context.Background())
+			results <- result
+		}(branch)
+	}
+	first, second := <-results, <-results
+
+	if len(first) != len(input) || !reflect.DeepEqual(first, second) {
+		t.Errorf("branches diverged:\n%v\n%v", first, second)
+	}
+}
+
+func TestTeeCollectAndCount(t *testing.T) {
+	input := make([]int, 500)
+	for i := range input {
+		input[i] = i
+	}
+	a, b := Tee(NewSliceStream(input))
+
+	counted := make(chan int, 1)
+	go func() {
+		n, err := b.Count(context.Background())
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		counted <- n
+	}()
+
+	result, err := a.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, input) {
+		t.Errorf("expected the collected branch to see every element in order")
+	}
+	if n := <-counted; n != len(input) {
+		t.Errorf("expected the counted branch to see %d elements, got %d", len(input), n)
+	}
+}
+
+func TestTeeBufferedSlowBranch(t *testing.T) {
+	input := make([]int, 30)
+	for i := range input {
+		input[i] = i
+	}
+	slow, fast := TeeBuffered(NewSliceStream(input), 10)
+
+	// The fast branch runs ahead of the idle one by up to the buffer size
+	ch := fast.ToChannel(context.Background())
+	for i := 0; i < 10; i++ {
+		select {
+		case v := <-ch:
+			if v != i {
+				t.Fatalf("expected %d, got %d", i, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("fast branch stalled after %d elements", i)
+		}
+	}
+
+	// Once the slow branch catches up, both see everything
+	rest := make(chan int, 1)
+	go func() {
+		n := 0
+		for range ch {
+			n++
+		}
+		rest <- n
+	}()
+	result, err := slow.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, input) {
+		t.Errorf("expected the slow branch to see every element, got %v", result)
+	}
+	if n := <-rest; n != len(input)-10 {
+		t.Errorf("expected the fast branch to see the remaining %d elements, got %d", len(input)-10, n)
+	}
+}
+
+func TestJoinMap(t *testing.T) {
+	names := map[int]string{1: "one", 2: "two", 4: "four"}
+
+	result, err := JoinMap(NewSliceStream([]int{1, 2, 3, 4, 5}),
+		func(x int) int { return x },
+		names,
+		func(x int) (string, bool) {
+			// Default odd misses, drop even ones
+			return "unknown", x%2 == 1
+		},
+	).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Pair[int, string]{{1, "one"}, {2, "two"}, {3, "unknown"}, {4, "four"}, {5, "unknown"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	result, err = JoinMap(NewSliceStream([]int{1, 3, 4}), func(x int) int { return x }, names, nil).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = []Pair[int, string]{{1, "one"}, {4, "four"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestInterleave(t *testing.T) {
+	result, err := Interleave(NewSliceStream([]int{1, 3, 5}), NewSliceStream([]int{2, 4})).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("expected [1 2 3 4 5], got %v", result)
+	}
+
+	result, err = Interleave(NewSliceStream([]int{1}), NewSliceStream([]int{2, 4, 6})).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 4, 6}) {
+		t.Errorf("expected [1 2 4 6], got %v", result)
+	}
+}
+
+func TestZip(t *testing.T) {
+	result, err := Zip(NewSliceStream([]int{1, 2, 3}), NewSliceStream([]string{"a", "b", "c"}),
+		func(n int, s string) Pair[int, string] { return Pair[int, string]{n, s} }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Pair[int, string]{{1, "a"}, {2, "b"}, {3, "c"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestZipUnequalLengths(t *testing.T) {
+	concat := func(n int, s string) string { return s + strconv.Itoa(n) }
+
+	result, err := Zip(NewSliceStream([]int{1, 2, 3, 4}), NewSliceStream([]string{"a", "b"}), concat).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []string{"a1", "b2"}) {
+		t.Errorf("expected [a1 b2], got %v", result)
+	}
+
+	n := 0
+	naturals := Generator(func() (int, bool) {
+		n++
+		return n, true
+	})
+	result, err = Zip(naturals, NewSliceStream([]string{"x", "y", "z"}), concat).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []string{"x1", "y2", "z3"}) {
+		t.Errorf("expected [x1 y2 z3], got %v", result)
+	}
+}
+
+func TestZipError(t *testing.T) {
+	failing := Cast[int](NewSliceStream([]any{1, "two", 3}))
+	_, err := Zip(NewSliceStream([]int{1, 2, 3}), failing, func(a, b int) int { return a + b }).
+		Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected the error of the second input to fail Zip")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	shards := []Stream[int, int]{
+		NewSliceStream([]int{1, 2, 3}),
+		NewSliceStream([]int{10, 20}),
+		NewSliceStream([]int{100, 200, 300, 400}),
+	}
+
+	result, err := Merge(shards...).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sort.Ints(result)
+	expected := []int{1, 2, 3, 10, 20, 100, 200, 300, 400}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	_, err = Merge(NewSliceStream([]int{1, 2}), Cast[int](NewSliceStream([]any{1, "two"}))).
+		Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected the error of an input to fail Merge")
+	}
+}
+
+func TestConcat(t *testing.T) {
+	result, err := Concat(NewSliceStream([]int{1, 2}), NewSliceStream([]int{3, 4})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 4}) {
+		t.Errorf("expected [1 2 3 4], got %v", result)
+	}
+
+	// A slow first page still precedes the second one
+	slow := NewSliceStream([]int{1, 2, 3}).Map(func(x int) int {
+		time.Sleep(time.Millisecond)
+		return x
+	})
+	result, err = Concat(slow, NewSliceStream([]int{4, 5})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("expected [1 2 3 4 5], got %v", result)
+	}
+
+	_, err = Concat(Cast[int](NewSliceStream([]any{1, "two"})), NewSliceStream([]int{3})).
+		Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected the error of an input to fail Concat")
+	}
+}
@@ -0,0 +1,221 @@
+package chain
+
+import "math"
+
+// Distinct drops elements equal to an earlier one, keeping first
+// occurrences in their original order. Every distinct element is kept in
+// memory until the stream ends.
+func Distinct[T comparable](s Stream[T, T]) Stream[T, T] {
+	return DistinctBy(s, func(item T) T { return item })
+}
+
+// DistinctBy drops elements whose key, as returned by keyFn, was seen on an
+// earlier element, keeping first occurrences in their original order. Every
+// distinct key is kept in memory until the stream ends.
+func DistinctBy[T any, K comparable](s Stream[T, T], keyFn func(T) K) Stream[T, T] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		seen := make(map[K]struct{})
+		for item := range receive(source, in.quit) {
+			k := keyFn(item)
+			if _, ok := seen[k]; ok {
+				continue
+			}
+			seen[k] = struct{}{}
+			if !send(out, item, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// DistinctUntilChanged drops elements equal to the one just before them,
+// so that only runs of repeated elements collapse. Unlike Distinct it keeps
+// no more than the previous element in memory.
+func DistinctUntilChanged[T comparable](s Stream[T, T]) Stream[T, T] {
+	return DistinctUntilChangedBy(s, func(item T) T { return item })
+}
+
+// DistinctUntilChangedBy drops elements whose key, as returned by keyFn,
+// equals the key of the element just before them
+func DistinctUntilChangedBy[T any, K comparable](s Stream[T, T], keyFn func(T) K) Stream[T, T] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		var last K
+		first := true
+		for item := range receive(source, in.quit) {
+			k := keyFn(item)
+			if !first && k == last {
+				continue
+			}
+			first, last = false, k
+			if !send(out, item, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// DistinctPersistent drops elements that have been seen before, including in
+// previous runs: the seen-set is seeded from load and handed to save once the
+// stage finishes, unless the pipeline failed. An element only counts as seen
+// once the next stage has taken it, so elements left over when a downstream
+// Take stops the stage are still new on the next run. The whole seen-set is
+// kept in memory and the stage runs sequentially.
+func DistinctPersistent[T comparable](s Stream[T, T], load func() map[T]struct{}, save func(map[T]struct{})) Stream[T, T] {
+	in := asStream(s)
+	source := in.channel()
+	// Unbuffered, so that a successful send means the element was taken
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+
+		seen := load()
+		if seen == nil {
+			seen = make(map[T]struct{})
+		}
+		defer func() {
+			if in.state.Err() == nil {
+				save(seen)
+			}
+		}()
+
+		for item := range receive(source, in.quit) {
+			if _, ok := seen[item]; ok {
+				continue
+			}
+			if !send(out, item, in.quit) {
+				return
+			}
+			seen[item] = struct{}{}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// DistinctWithCount emits each distinct element once, in first-seen order,
+// paired with the number of times it appeared. Since a count is only final
+// once the source ends, nothing is emitted until then and every distinct
+// element is buffered.
+func DistinctWithCount[T comparable](s Stream[T, T]) Stream[Pair[T, int], Pair[T, int]] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan Pair[T, int], 1)
+
+	go func() {
+		defer close(out)
+
+		var order []T
+		counts := make(map[T]int)
+		for item := range receive(source, in.quit) {
+			if counts[item] == 0 {
+				order = append(order, item)
+			}
+			counts[item]++
+		}
+		if in.state.Err() != nil {
+			return
+		}
+
+		for _, item := range order {
+			if !send(out, Pair[T, int]{First: item, Second: counts[item]}, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// bloomFilter is a fixed-size bloom filter over 64-bit hashes
+type bloomFilter struct {
+	bits []uint64
+	k    uint64
+}
+
+// newBloomFilter sizes a filter for n elements and the false positive rate p
+func newBloomFilter(n int, p float64) *bloomFilter {
+	m := math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2))
+	k := math.Max(1, math.Round(m/float64(n)*math.Ln2))
+	return &bloomFilter{bits: make([]uint64, (uint64(m)+63)/64), k: uint64(k)}
+}
+
+// add records h and reports whether it may have been added before. The k
+// bit positions are derived from h by double hashing.
+func (b *bloomFilter) add(h uint64) bool {
+	m := uint64(len(b.bits)) * 64
+	h2 := mix64(h) | 1
+	present := true
+	for i := uint64(0); i < b.k; i++ {
+		bit := (h + i*h2) % m
+		word, mask := bit/64, uint64(1)<<(bit%64)
+		if b.bits[word]&mask == 0 {
+			present = false
+			b.bits[word] |= mask
+		}
+	}
+	return present
+}
+
+// mix64 is the splitmix64 finalizer
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
+
+// DistinctBloom drops elements that have probably been seen before, using a
+// bloom filter sized for expectedN distinct elements so that memory stays
+// bounded however long the stream is. The result is approximate in one
+// direction only: a duplicate is always dropped, but a new element is also
+// dropped, as a false positive, with a probability of about
+// falsePositiveRate as long as no more than expectedN distinct elements have
+// gone through, growing beyond that. Elements are told apart by hash alone,
+// so hash collisions count as duplicates too. It panics if expectedN is not
+// positive or falsePositiveRate is not between 0 and 1.
+func DistinctBloom[T any](s Stream[T, T], expectedN int, falsePositiveRate float64, hash func(T) uint64) Stream[T, T] {
+	if expectedN <= 0 {
+		panic("chain: DistinctBloom expected count must be positive")
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		panic("chain: DistinctBloom false positive rate must be between 0 and 1")
+	}
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		filter := newBloomFilter(expectedN, falsePositiveRate)
+		for item := range receive(source, in.quit) {
+			if filter.add(hash(item)) {
+				continue
+			}
+			if !send(out, item, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
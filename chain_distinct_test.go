@@ -0,0 +1,164 @@
+package chain
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDistinctPersistent(t *testing.T) {
+	// State left behind by a previous run
+	state := map[int]struct{}{1: {}, 2: {}}
+
+	load := func() map[int]struct{} {
+		seen := make(map[int]struct{}, len(state))
+		for k := range state {
+			seen[k] = struct{}{}
+		}
+		return seen
+	}
+	saved := make(chan map[int]struct{}, 1)
+	save := func(seen map[int]struct{}) { saved <- seen }
+
+	result, err := DistinctPersistent(NewSliceStream([]int{1, 3, 2, 4, 3, 5}), load, save).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !reflect.DeepEqual(result, []int{3, 4, 5}) {
+		t.Errorf("expected [3 4 5], got %v", result)
+	}
+
+	expected := map[int]struct{}{1: {}, 2: {}, 3: {}, 4: {}, 5: {}}
+	if got := <-saved; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected saved set %v, got %v", expected, got)
+	}
+}
+
+func TestDistinctPersistentTake(t *testing.T) {
+	saved := make(chan map[int]struct{}, 1)
+	load := func() map[int]struct{} { return nil }
+	save := func(seen map[int]struct{}) { saved <- seen }
+
+	result, err := DistinctPersistent(NewSliceStream([]int{1, 2, 3, 4, 5, 6, 7, 8}), load, save).
+		Take(2).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", result)
+	}
+
+	// Only the delivered elements are remembered for the next run
+	expected := map[int]struct{}{1: {}, 2: {}}
+	if got := <-saved; !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected saved set %v, got %v", expected, got)
+	}
+}
+
+func TestDistinctWithCount(t *testing.T) {
+	result, err := DistinctWithCount(NewSliceStream([]int{1, 1, 2, 3, 3, 3})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Pair[int, int]{{1, 2}, {2, 1}, {3, 3}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestDistinctBloom(t *testing.T) {
+	const n = 2000
+	const rate = 0.01
+
+	// Every value appears twice, the duplicates only after all first copies
+	input := make([]int, 0, 2*n)
+	for pass := 0; pass < 2; pass++ {
+		for i := 0; i < n; i++ {
+			input = append(input, i)
+		}
+	}
+
+	hash := func(x int) uint64 { return mix64(uint64(x)) }
+	result, err := DistinctBloom(NewSliceStream(input), n, rate, hash).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	seen := make(map[int]bool, len(result))
+	for _, x := range result {
+		if seen[x] {
+			t.Fatalf("duplicate %d was not dropped", x)
+		}
+		seen[x] = true
+	}
+
+	// Values missing from the output were false positives
+	falsePositives := n - len(result)
+	if falsePositives > 2*rate*n {
+		t.Errorf("expected at most %v false positives, got %d", 2*rate*n, falsePositives)
+	}
+}
+
+func TestDistinct(t *testing.T) {
+	result, err := Distinct(NewSliceStream([]int{3, 1, 3, 2, 1, 4})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{3, 1, 2, 4}) {
+		t.Errorf("expected [3 1 2 4], got %v", result)
+	}
+}
+
+func TestDistinctBy(t *testing.T) {
+	users := []User{{Age: 25, Score: 80}, {Age: 30, Score: 95}, {Age: 25, Score: 70}, {Age: 22, Score: 60}}
+
+	result, err := DistinctBy(NewSliceStream(users), func(u User) int { return u.Age }).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []User{{Age: 25, Score: 80}, {Age: 30, Score: 95}, {Age: 22, Score: 60}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestDistinctUntilChanged(t *testing.T) {
+	result, err := DistinctUntilChanged(NewSliceStream([]int{1, 1, 2, 2, 2, 3, 1})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 1}) {
+		t.Errorf("expected [1 2 3 1], got %v", result)
+	}
+
+	// The zero value is not mistaken for a previous element
+	result, err = DistinctUntilChanged(NewSliceStream([]int{0, 0, 1})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{0, 1}) {
+		t.Errorf("expected [0 1], got %v", result)
+	}
+}
+
+func TestDistinctUntilChangedBy(t *testing.T) {
+	type reading struct {
+		At    int
+		Value string
+	}
+	readings := []reading{{1, "low"}, {2, "low"}, {3, "high"}, {4, "high"}, {5, "low"}}
+
+	result, err := DistinctUntilChangedBy(NewSliceStream(readings), func(r reading) string { return r.Value }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []reading{{1, "low"}, {3, "high"}, {5, "low"}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
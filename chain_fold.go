@@ -0,0 +1,50 @@
+package chain
+
+import "context"
+
+// Fold reduces s into an accumulator of any type, starting from init. Unlike
+// Reduce an empty stream is not an error: the result is init.
+func Fold[T any, R any](ctx context.Context, s Stream[T, T], init R, fn func(R, T) R) (R, error) {
+	acc := init
+	err := asStream(s).drain(ctx, func(item T) bool {
+		acc = fn(acc, item)
+		return true
+	})
+	if err != nil {
+		var zero R
+		return zero, err
+	}
+	return acc, nil
+}
+
+// FoldParallel folds s on each of its workers separately, every partition
+// starting from identity, and then merges the partial results with combine
+// in worker order. Which elements end up in which partition is arbitrary, so
+// accumulate and combine must not depend on it, and identity must be
+// neutral for combine. Since every partition starts from the same identity
+// value, accumulate must not modify it in place when it is a map, slice or
+// pointer; start from nil and allocate lazily instead.
+func FoldParallel[T any, A any](s Stream[T, T], identity A, accumulate func(A, T) A, combine func(A, A) A) (A, error) {
+	in := asStream(s)
+	defer in.quit.fire()
+
+	source := in.channel()
+	partials := make([]A, max(in.workers, 1))
+	in.fanOut("FoldParallel", func(worker int) {
+		acc := identity
+		for item := range receive(source, in.quit) {
+			acc = accumulate(acc, item)
+		}
+		partials[worker] = acc
+	})
+	if err := in.state.Err(); err != nil {
+		var zero A
+		return zero, err
+	}
+
+	result := partials[0]
+	for _, partial := range partials[1:] {
+		result = combine(result, partial)
+	}
+	return result, nil
+}
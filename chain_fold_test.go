@@ -0,0 +1,128 @@
+package chain
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestFold(t *testing.T) {
+	ctx := context.Background()
+	join := func(acc string, x int) string {
+		if acc != "" {
+			acc += ","
+		}
+		return acc + strconv.Itoa(x)
+	}
+
+	joined, err := Fold(ctx, NewSliceStream([]int{1, 2, 3}), "", join)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if joined != "1,2,3" {
+		t.Errorf("expected 1,2,3, got %q", joined)
+	}
+
+	freq, err := Fold(ctx, NewSliceStream([]int{3, 1, 3, 2, 3, 1}), map[int]int{},
+		func(m map[int]int, x int) map[int]int {
+			m[x]++
+			return m
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(freq, map[int]int{1: 2, 2: 1, 3: 3}) {
+		t.Errorf("expected map[1:2 2:1 3:3], got %v", freq)
+	}
+
+	// An empty stream folds to init rather than failing like Reduce
+	empty, err := Fold(ctx, NewSliceStream([]int{}), "init", join)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if empty != "init" {
+		t.Errorf("expected init, got %q", empty)
+	}
+
+	_, err = Fold(ctx, Cast[int](NewSliceStream([]any{1, "two"})), "", join)
+	if err == nil {
+		t.Errorf("expected the pipeline error to fail Fold")
+	}
+}
+
+func TestFoldParallel(t *testing.T) {
+	input := make([]int, 1000)
+	for i := range input {
+		input[i] = i + 1
+	}
+	add := func(a, b int) int { return a + b }
+
+	sum, err := FoldParallel(NewSliceStream(input).Parallel(4), 0, add, add)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sum != 500500 {
+		t.Errorf("expected 500500, got %d", sum)
+	}
+
+	// Per-partition histograms of the last digit, merged at the end
+	histogram, err := FoldParallel(NewSliceStream(input).Parallel(4), map[int]int(nil),
+		func(h map[int]int, x int) map[int]int {
+			if h == nil {
+				h = make(map[int]int)
+			}
+			h[x%10]++
+			return h
+		},
+		func(a, b map[int]int) map[int]int {
+			if a == nil {
+				return b
+			}
+			for k, v := range b {
+				a[k] += v
+			}
+			return a
+		})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := make(map[int]int)
+	for d := 0; d < 10; d++ {
+		expected[d] = 100
+	}
+	if !reflect.DeepEqual(histogram, expected) {
+		t.Errorf("expected %v, got %v", expected, histogram)
+	}
+}
+
+func benchmarkFold(b *testing.B, parallel bool) {
+	input := make([]int, 10000)
+	for i := range input {
+		input[i] = i
+	}
+	// A combiner with some work per element, where partitions pay off
+	accumulate := func(acc, x int) int {
+		for i := 0; i < 100; i++ {
+			x = x*31 + i
+		}
+		return acc + x%7
+	}
+	add := func(a, b int) int { return a + b }
+
+	for i := 0; i < b.N; i++ {
+		var err error
+		if parallel {
+			_, err = FoldParallel(NewSliceStream(input).Parallel(4), 0, accumulate, add)
+		} else {
+			_, err = Fold(context.Background(), NewSliceStream(input), 0, accumulate)
+		}
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFold(b *testing.B) { benchmarkFold(b, false) }
+
+func BenchmarkFoldParallel(b *testing.B) { benchmarkFold(b, true) }
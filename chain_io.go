@@ -0,0 +1,354 @@
+package chain
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+)
+
+// ChunkOption configures NewChunkStream and NewGzipStream
+type ChunkOption func(*chunkConfig)
+
+type chunkConfig struct {
+	pool *sync.Pool
+}
+
+// WithBufferPool makes the stream take its chunk buffers from pool instead
+// of allocating a new one for every chunk. The pool holds []byte values;
+// ones with a capacity below the chunk size are dropped. The consumer should
+// hand every chunk back with pool.Put once it is done with it, and must not
+// retain the chunk, or any slice of it, after that.
+func WithBufferPool(pool *sync.Pool) ChunkOption {
+	return func(c *chunkConfig) { c.pool = pool }
+}
+
+// buffer returns a buffer of size bytes, from the pool if there is one
+func (c *chunkConfig) buffer(size int) []byte {
+	if c.pool != nil {
+		if buf, ok := c.pool.Get().([]byte); ok && cap(buf) >= size {
+			return buf[:size]
+		}
+	}
+	return make([]byte, size)
+}
+
+// chunkReader returns a function reading the next chunk of size bytes from r.
+// It reports false at the end of r, recording any read error in p.
+func chunkReader(r io.Reader, size int, p *pipeline, opts []ChunkOption) func() ([]byte, bool) {
+	c := &chunkConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return func() ([]byte, bool) {
+		buf := c.buffer(size)
+		n, err := io.ReadFull(r, buf)
+		switch err {
+		case nil, io.ErrUnexpectedEOF:
+			return buf[:n], true
+		case io.EOF:
+		default:
+			p.fail(err)
+		}
+		if c.pool != nil {
+			c.pool.Put(buf[:0])
+		}
+		return nil, false
+	}
+}
+
+// NewChunkStream creates a stream of the bytes read from r, split into chunks
+// of chunkSize bytes; only the last chunk may be shorter. Read errors other
+// than io.EOF are surfaced through the terminal operation.
+func NewChunkStream(r io.Reader, chunkSize int, opts ...ChunkOption) Stream[[]byte, []byte] {
+	if chunkSize <= 0 {
+		panic("chain: NewChunkStream chunk size must be positive")
+	}
+	s := newIterSource[[]byte](nil, 1)
+	s.next = chunkReader(r, chunkSize, s.state, opts)
+	return s
+}
+
+// FromReader creates a stream of the lines read from r, without their line
+// endings. The stream ends at EOF; other read errors, and lines too long for
+// a bufio.Scanner, are surfaced through the terminal operation.
+func FromReader(r io.Reader) Stream[string, string] {
+	s := newIterSource[string](nil, 1)
+	scanner := bufio.NewScanner(r)
+	s.next = func() (string, bool) {
+		if scanner.Scan() {
+			return scanner.Text(), true
+		}
+		if err := scanner.Err(); err != nil {
+			s.state.fail(err)
+		}
+		return "", false
+	}
+	return s
+}
+
+// NewGzipStream creates a stream of the decompressed contents of the gzip
+// data read from r, split into chunks of chunkSize bytes. Invalid gzip data
+// is surfaced through the terminal operation.
+func NewGzipStream(r io.Reader, chunkSize int, opts ...ChunkOption) Stream[[]byte, []byte] {
+	if chunkSize <= 0 {
+		panic("chain: NewGzipStream chunk size must be positive")
+	}
+	s := newIterSource[[]byte](nil, 1)
+	var read func() ([]byte, bool)
+	s.next = func() ([]byte, bool) {
+		// The gzip header is only read once the first chunk is requested
+		if read == nil {
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				s.state.fail(err)
+				return nil, false
+			}
+			read = chunkReader(zr, chunkSize, s.state, opts)
+		}
+		return read()
+	}
+	return s
+}
+
+// WriteGzip gzip-compresses all chunks of s to w. The gzip stream is
+// finalized once s is exhausted; w itself is not closed.
+func WriteGzip(ctx context.Context, s Stream[[]byte, []byte], w io.Writer) error {
+	in := asStream(s)
+	defer in.quit.fire()
+
+	zw := gzip.NewWriter(w)
+	var writeErr error
+	err := in.drain(ctx, func(chunk []byte) bool {
+		_, writeErr = zw.Write(chunk)
+		return writeErr == nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// msgpackBin32 is the msgpack type byte of a bin 32 object, which is followed
+// by a 4-byte big-endian length and the data
+const msgpackBin32 = 0xc6
+
+// WriteMsgpack writes every element of s to w, encoded by marshal, which is
+// expected to produce msgpack. Each blob is framed as a msgpack bin 32
+// object, so the output is itself a valid msgpack sequence and can be read
+// back with NewMsgpackStream.
+func WriteMsgpack[T any](ctx context.Context, s Stream[T, T], w io.Writer, marshal func(T) ([]byte, error)) error {
+	in := asStream(s)
+	defer in.quit.fire()
+
+	var header [5]byte
+	header[0] = msgpackBin32
+	write := func(item T) error {
+		blob, err := marshal(item)
+		if err != nil {
+			return err
+		}
+		if uint64(len(blob)) > math.MaxUint32 {
+			return fmt.Errorf("chain: msgpack blob of %d bytes is too large", len(blob))
+		}
+		binary.BigEndian.PutUint32(header[1:], uint32(len(blob)))
+		if _, err := w.Write(header[:]); err != nil {
+			return err
+		}
+		_, err = w.Write(blob)
+		return err
+	}
+
+	var writeErr error
+	err := in.drain(ctx, func(item T) bool {
+		writeErr = write(item)
+		return writeErr == nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	return err
+}
+
+// NewMsgpackStream creates a stream of the elements written by WriteMsgpack
+// to r, each decoded by unmarshal. Read, framing and unmarshal errors are
+// surfaced through the terminal operation.
+func NewMsgpackStream[T any](r io.Reader, unmarshal func([]byte) (T, error)) Stream[T, T] {
+	s := newIterSource[T](nil, 1)
+	s.next = func() (T, bool) {
+		var zero T
+		var header [5]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err != io.EOF {
+				s.state.fail(err)
+			}
+			return zero, false
+		}
+		if header[0] != msgpackBin32 {
+			s.state.fail(fmt.Errorf("chain: unexpected msgpack type byte %#x", header[0]))
+			return zero, false
+		}
+		blob := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		if _, err := io.ReadFull(r, blob); err != nil {
+			s.state.fail(err)
+			return zero, false
+		}
+		item, err := unmarshal(blob)
+		if err != nil {
+			s.state.fail(err)
+			return zero, false
+		}
+		return item, true
+	}
+	return s
+}
+
+// WriteSharded writes the elements of s, rendered by render, round-robin to
+// shards writers obtained from open, with one goroutine writing to each
+// shard. Every writer that was opened is closed at the end, whatever
+// happened. The first error, be it from ctx, the pipeline, open, a write or
+// a close, stops the writing and is returned. It panics if shards is not
+// positive.
+func WriteSharded[T any](ctx context.Context, s Stream[T, T], shards int, open func(shard int) (io.WriteCloser, error), render func(T) []byte) (err error) {
+	if shards <= 0 {
+		panic("chain: WriteSharded shard count must be positive")
+	}
+	in := asStream(s)
+	defer in.quit.fire()
+
+	writers := make([]io.WriteCloser, 0, shards)
+	failed := &pipeline{}
+	defer func() {
+		for _, w := range writers {
+			if cerr := w.Close(); cerr != nil {
+				failed.fail(cerr)
+			}
+		}
+		err = failed.Err()
+	}()
+	for i := 0; i < shards; i++ {
+		w, openErr := open(i)
+		if openErr != nil {
+			failed.fail(openErr)
+			return
+		}
+		writers = append(writers, w)
+	}
+
+	stop := newSignal()
+	inputs := make([]chan T, shards)
+	var wg sync.WaitGroup
+	wg.Add(shards)
+	for i := range inputs {
+		inputs[i] = make(chan T, 1)
+		go func(w io.Writer, input <-chan T) {
+			defer wg.Done()
+			for item := range input {
+				if _, err := w.Write(render(item)); err != nil {
+					failed.fail(err)
+					stop.fire()
+					return
+				}
+			}
+		}(writers[i], inputs[i])
+	}
+
+	shard := 0
+	if drainErr := in.drain(ctx, func(item T) bool {
+		if !send(inputs[shard], item, stop) {
+			return false
+		}
+		shard = (shard + 1) % shards
+		return true
+	}); drainErr != nil {
+		failed.fail(drainErr)
+	}
+	for _, input := range inputs {
+		close(input)
+	}
+	wg.Wait()
+	return
+}
+
+// WriteJSONArray implements Stream.WriteJSONArray. Elements are encoded with
+// encoding/json and written one at a time, so the stream is never held in
+// memory as a whole; an empty stream produces []. Unless every element was
+// written, the output is left incomplete.
+func (s *stream[T, R]) WriteJSONArray(ctx context.Context, w io.Writer) error {
+	sep := []byte{'['}
+	var writeErr error
+	err := s.drain(ctx, func(item T) bool {
+		data, err := json.Marshal(item)
+		if err == nil {
+			_, err = w.Write(sep)
+		}
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		writeErr = err
+		sep[0] = ','
+		return err == nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return err
+	}
+	if sep[0] == '[' {
+		_, err := io.WriteString(w, "[]")
+		return err
+	}
+	_, err = io.WriteString(w, "]")
+	return err
+}
+
+// BridgeReader implements Stream.BridgeReader. A goroutine renders the
+// elements into an io.Pipe, so the pipeline can feed any API that consumes an
+// io.Reader. Pipeline and render errors are returned by Read once the bytes
+// before them have been read. Closing the reader or cancelling ctx stops the
+// goroutine and the pipeline, even while the source is idle.
+func (s *stream[T, R]) BridgeReader(ctx context.Context, render func(w io.Writer, v T) error) io.ReadCloser {
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	stop := context.AfterFunc(ctx, func() {
+		pw.CloseWithError(contextError(ctx.Err()))
+	})
+
+	go func() {
+		defer cancel()
+		defer stop()
+
+		var renderErr error
+		err := s.drain(ctx, func(item T) bool {
+			renderErr = render(pw, item)
+			return renderErr == nil
+		})
+		if renderErr != nil {
+			err = renderErr
+		}
+		pw.CloseWithError(err)
+	}()
+
+	return &bridgeReader{PipeReader: pr, cancel: cancel}
+}
+
+// bridgeReader is the reader returned by BridgeReader. Closing it cancels
+// the goroutine feeding the pipe.
+type bridgeReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *bridgeReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
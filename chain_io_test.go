@@ -0,0 +1,381 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestNewChunkStream(t *testing.T) {
+	chunks, err := NewChunkStream(strings.NewReader("abcdefgh"), 3).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"abc", "def", "gh"}
+	if len(chunks) != len(expected) {
+		t.Fatalf("expected %d chunks, got %d", len(expected), len(chunks))
+	}
+	for i, v := range expected {
+		if string(chunks[i]) != v {
+			t.Errorf("at index %d: expected %q, got %q", i, v, chunks[i])
+		}
+	}
+}
+
+func TestGzipRoundTrip(t *testing.T) {
+	original := [][]byte{
+		[]byte("hello, "),
+		bytes.Repeat([]byte("chain "), 1000),
+		[]byte("goodbye"),
+	}
+
+	var compressed bytes.Buffer
+	if err := WriteGzip(context.Background(), NewSliceStream(original), &compressed); err != nil {
+		t.Fatalf("failed to compress: %v", err)
+	}
+	if compressed.Len() >= len(bytes.Join(original, nil)) {
+		t.Errorf("expected compressed output to be smaller, got %d bytes", compressed.Len())
+	}
+
+	chunks, err := NewGzipStream(&compressed, 512).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("failed to decompress: %v", err)
+	}
+	for _, chunk := range chunks[:len(chunks)-1] {
+		if len(chunk) != 512 {
+			t.Errorf("expected full chunks of 512 bytes, got %d", len(chunk))
+		}
+	}
+	if got, want := bytes.Join(chunks, nil), bytes.Join(original, nil); !bytes.Equal(got, want) {
+		t.Errorf("round trip mismatch: got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestWriteGzipIdleSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	var buf bytes.Buffer
+	if err := WriteGzip(ctx, NewChanStream(make(chan []byte)), &buf); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestNewGzipStreamInvalid(t *testing.T) {
+	_, err := NewGzipStream(strings.NewReader("not gzip"), 16).Collect(context.Background())
+	if err == nil {
+		t.Error("expected an error for invalid gzip data")
+	}
+}
+
+func renderUser(w io.Writer, u User) error {
+	_, err := fmt.Fprintf(w, "%d:%d\n", u.Age, u.Score)
+	return err
+}
+
+func TestBridgeReader(t *testing.T) {
+	users := []User{{Age: 25, Score: 80}, {Age: 30, Score: 95}, {Age: 22, Score: 70}}
+
+	r := NewSliceStream(users).BridgeReader(context.Background(), renderUser)
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := "25:80\n30:95\n22:70\n"; string(data) != expected {
+		t.Errorf("expected %q, got %q", expected, data)
+	}
+}
+
+func TestBridgeReaderStops(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// Closing the reader stops the writer goroutine and the infinite source
+	r := forever(User{Age: 1}).BridgeReader(context.Background(), renderUser)
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.Close()
+	waitForGoroutines(t, before)
+
+	// So does cancelling the context
+	ctx, cancel := context.WithCancel(context.Background())
+	r = forever(User{Age: 1}).BridgeReader(ctx, renderUser)
+	cancel()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+	waitForGoroutines(t, before)
+
+	// Including while nothing arrives from the source
+	r = NewChanStream(make(chan User)).BridgeReader(context.Background(), renderUser)
+	r.Close()
+	waitForGoroutines(t, before)
+
+	ctx, cancel = context.WithCancel(context.Background())
+	r = NewChanStream(make(chan User)).BridgeReader(ctx, renderUser)
+	cancel()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+	waitForGoroutines(t, before)
+}
+
+// forever returns an infinite stream of v
+func forever[T any](v T) Stream[T, T] {
+	return Generator(func() (T, bool) { return v, true })
+}
+
+// marshalUser encodes u as the msgpack array [age, score]
+func marshalUser(u User) ([]byte, error) {
+	b := []byte{0x92, 0xce, 0, 0, 0, 0, 0xce, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[2:], uint32(u.Age))
+	binary.BigEndian.PutUint32(b[7:], uint32(u.Score))
+	return b, nil
+}
+
+// unmarshalUser decodes the output of marshalUser
+func unmarshalUser(b []byte) (User, error) {
+	if len(b) != 11 || b[0] != 0x92 || b[1] != 0xce || b[6] != 0xce {
+		return User{}, fmt.Errorf("unexpected user encoding %x", b)
+	}
+	return User{
+		Age:   int(binary.BigEndian.Uint32(b[2:])),
+		Score: int(binary.BigEndian.Uint32(b[7:])),
+	}, nil
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	users := []User{{Age: 25, Score: 80}, {Age: 30, Score: 95}, {Age: 22, Score: 70}}
+
+	var buf bytes.Buffer
+	if err := WriteMsgpack(context.Background(), NewSliceStream(users), &buf, marshalUser); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.Len() != len(users)*(5+11) {
+		t.Errorf("expected %d bytes, got %d", len(users)*(5+11), buf.Len())
+	}
+
+	decoded, err := NewMsgpackStream(&buf, unmarshalUser).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, users) {
+		t.Errorf("expected %v, got %v", users, decoded)
+	}
+
+	// A truncated blob fails the stream
+	var truncated bytes.Buffer
+	if err := WriteMsgpack(context.Background(), NewSliceStream(users[:1]), &truncated, marshalUser); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	truncated.Truncate(truncated.Len() - 1)
+	_, err = NewMsgpackStream(&truncated, unmarshalUser).Collect(context.Background())
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// Cancelling while the source is idle stops the writing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := WriteMsgpack(ctx, NewChanStream(make(chan User)), &buf, marshalUser); !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestWriteJSONArray(t *testing.T) {
+	users := []User{{Age: 25, Score: 80}, {Age: 30, Score: 95}, {Age: 22, Score: 70}}
+
+	var buf bytes.Buffer
+	if err := NewSliceStream(users).WriteJSONArray(context.Background(), &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var decoded []User
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not a JSON array: %v\n%s", err, buf.String())
+	}
+	if !reflect.DeepEqual(decoded, users) {
+		t.Errorf("expected %v, got %v", users, decoded)
+	}
+
+	buf.Reset()
+	if err := NewSliceStream([]User{}).WriteJSONArray(context.Background(), &buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if buf.String() != "[]" {
+		t.Errorf("expected [], got %q", buf.String())
+	}
+
+	// A source that stalls after the first element is abandoned on timeout
+	idle := make(chan User, 1)
+	idle <- users[0]
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	buf.Reset()
+	if err := NewChanStream(idle).WriteJSONArray(ctx, &buf); !errors.Is(err, ErrDeadline) {
+		t.Errorf("expected ErrDeadline, got %v", err)
+	}
+}
+
+func TestWithBufferPool(t *testing.T) {
+	pool := &sync.Pool{New: func() any { return make([]byte, 0, 4) }}
+
+	var data []byte
+	err := NewChunkStream(strings.NewReader("abcdefghij"), 4, WithBufferPool(pool)).ForEach(func(chunk []byte) {
+		data = append(data, chunk...)
+		pool.Put(chunk)
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(data) != "abcdefghij" {
+		t.Errorf("expected abcdefghij, got %q", data)
+	}
+
+	// Buffers too small for a chunk are not used
+	pool.Put(make([]byte, 0, 2))
+	chunks, err := NewChunkStream(strings.NewReader("abcdef"), 4, WithBufferPool(pool)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chunks) != 2 || string(chunks[0]) != "abcd" || string(chunks[1]) != "ef" {
+		t.Errorf("expected [abcd ef], got %q", chunks)
+	}
+}
+
+func benchmarkChunkStream(b *testing.B, pooled bool) {
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	pool := &sync.Pool{New: func() any { return make([]byte, 0, 4096) }}
+	var opts []ChunkOption
+	if pooled {
+		opts = append(opts, WithBufferPool(pool))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := NewChunkStream(bytes.NewReader(data), 4096, opts...).ForEach(func(chunk []byte) {
+			if pooled {
+				pool.Put(chunk)
+			}
+		})
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkChunkStream(b *testing.B) { benchmarkChunkStream(b, false) }
+
+func BenchmarkChunkStreamPooled(b *testing.B) { benchmarkChunkStream(b, true) }
+
+// bufferCloser is an in-memory io.WriteCloser recording whether it was closed
+type bufferCloser struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestWriteSharded(t *testing.T) {
+	shards := make([]*bufferCloser, 3)
+	open := func(shard int) (io.WriteCloser, error) {
+		shards[shard] = &bufferCloser{}
+		return shards[shard], nil
+	}
+	render := func(x int) []byte { return []byte(fmt.Sprintf("%d\n", x)) }
+
+	input := make([]int, 10)
+	for i := range input {
+		input[i] = i
+	}
+	if err := WriteSharded(context.Background(), NewSliceStream(input), 3, open, render); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var written []int
+	for i, shard := range shards {
+		if !shard.closed {
+			t.Errorf("shard %d was not closed", i)
+		}
+		for _, line := range strings.Fields(shard.String()) {
+			var x int
+			fmt.Sscan(line, &x)
+			if x%3 != i {
+				t.Errorf("expected %d to be written round-robin, found it in shard %d", x, i)
+			}
+			written = append(written, x)
+		}
+	}
+	sort.Ints(written)
+	if !reflect.DeepEqual(written, input) {
+		t.Errorf("expected %v across the shards, got %v", input, written)
+	}
+
+	// A failing open still closes the writers opened before it
+	errOpen := errors.New("open failed")
+	opened := make([]*bufferCloser, 0, 3)
+	err := WriteSharded(context.Background(), NewSliceStream(input), 3, func(shard int) (io.WriteCloser, error) {
+		if shard == 2 {
+			return nil, errOpen
+		}
+		w := &bufferCloser{}
+		opened = append(opened, w)
+		return w, nil
+	}, render)
+	if !errors.Is(err, errOpen) {
+		t.Errorf("expected the open error, got %v", err)
+	}
+	for i, w := range opened {
+		if !w.closed {
+			t.Errorf("shard %d was not closed", i)
+		}
+	}
+
+	// Cancelling while the source is idle stops the writing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = WriteSharded(ctx, NewChanStream(make(chan int)), 3, open, render)
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+	for i, shard := range shards {
+		if !shard.closed {
+			t.Errorf("shard %d was not closed after cancellation", i)
+		}
+	}
+}
+
+func TestFromReader(t *testing.T) {
+	lines, err := FromReader(strings.NewReader("alpha\nbeta\r\n\ngamma")).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(lines, []string{"alpha", "beta", "", "gamma"}) {
+		t.Errorf("expected [alpha beta  gamma], got %q", lines)
+	}
+
+	errRead := errors.New("connection reset")
+	r := io.MultiReader(strings.NewReader("first\n"), iotest.ErrReader(errRead))
+	lines, err = FromReader(r).Collect(context.Background())
+	if !errors.Is(err, errRead) {
+		t.Errorf("expected the read error, got %v (lines %q)", err, lines)
+	}
+}
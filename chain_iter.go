@@ -0,0 +1,56 @@
+package chain
+
+import (
+	"context"
+	"iter"
+)
+
+// Seq2 implements Stream.Seq2, for use as
+//
+//	for v, err := range s.Seq2(ctx) {
+//		if err != nil {
+//			// the pipeline failed, or ctx is done
+//		}
+//	}
+//
+// The pipeline only runs while the loop does; breaking out of it stops the
+// pipeline. Cancelling ctx ends the loop even while a channel-backed stream
+// is idle. The iterator can be ranged over once.
+func (s *stream[T, R]) Seq2(ctx context.Context) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		drain := s.drain
+		if s.source == nil && s.next != nil {
+			// Nothing runs ahead of the loop when the source can be pulled
+			drain = s.pull
+		}
+		if err := drain(ctx, func(item T) bool {
+			return yield(item, nil)
+		}); err != nil {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
+
+// ToChannel implements Stream.ToChannel. Elements are forwarded by a
+// goroutine that stops the pipeline once ctx is done, even while it waits on
+// an idle source, so a caller that stops reading early must cancel ctx to
+// release it. The channel does not report why the stream ended; use Seq2
+// when pipeline errors matter.
+func (s *stream[T, R]) ToChannel(ctx context.Context) <-chan T {
+	out := make(chan T)
+
+	go func() {
+		defer close(out)
+		s.drain(ctx, func(item T) bool {
+			select {
+			case out <- item:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	return out
+}
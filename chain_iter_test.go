@@ -0,0 +1,139 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSeq2(t *testing.T) {
+	var result []int
+	for v, err := range NewSliceStream([]int{1, 2, 3}).Map(func(x int) int { return x * 10 }).Seq2(context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		result = append(result, v)
+	}
+	if !reflect.DeepEqual(result, []int{10, 20, 30}) {
+		t.Errorf("expected [10 20 30], got %v", result)
+	}
+}
+
+func TestSeq2Error(t *testing.T) {
+	errBad := errors.New("bad element")
+	stream := MapErrIndexed(NewSliceStream([]int{1, 2, 3, 4}), func(x int) (int, error) {
+		if x == 3 {
+			return 0, errBad
+		}
+		return x * 10, nil
+	})
+
+	var values []int
+	var failure error
+	for v, err := range stream.Seq2(context.Background()) {
+		if err != nil {
+			failure = err
+			break
+		}
+		values = append(values, v)
+	}
+
+	if !errors.Is(failure, errBad) {
+		t.Fatalf("expected the pipeline error in the loop, got %v", failure)
+	}
+	var indexed *IndexedError
+	if !errors.As(failure, &indexed) || indexed.Index != 2 {
+		t.Errorf("expected an IndexedError at index 2, got %v", failure)
+	}
+	// Elements before the failing one may or may not have made it through
+	if !reflect.DeepEqual(values, []int{10, 20}[:len(values)]) {
+		t.Errorf("expected a prefix of [10 20], got %v", values)
+	}
+}
+
+func TestSeq2Break(t *testing.T) {
+	i := 0
+	gen := Generator(func() (int, bool) {
+		i++
+		return i, true
+	})
+
+	for v, err := range gen.Seq2(context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v == 3 {
+			break
+		}
+	}
+	if i != 3 {
+		t.Errorf("expected the generator to stop after 3 calls, got %d", i)
+	}
+}
+
+func TestSeq2IdleSource(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	var failure error
+	for _, err := range NewChanStream(make(chan int)).Seq2(ctx) {
+		failure = err
+	}
+	if !errors.Is(failure, ErrDeadline) {
+		t.Errorf("expected ErrDeadline, got %v", failure)
+	}
+}
+
+func TestToChannel(t *testing.T) {
+	count := 0
+	for range NewSliceStream([]int{1, 2, 3, 4, 5}).Filter(func(x int) bool { return x > 1 }).ToChannel(context.Background()) {
+		count++
+	}
+	if count != 4 {
+		t.Errorf("expected 4 elements, got %d", count)
+	}
+}
+
+func TestToChannelCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := LoopGenerator(-1, countTo(3)).ToChannel(ctx)
+
+	if v := <-ch; v != 1 {
+		t.Fatalf("expected 1, got %d", v)
+	}
+	cancel()
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected the channel to close after cancellation")
+		}
+	}
+}
+
+func TestToChannelIdleSource(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// Nothing is ever sent on the source, so only cancellation ends the stream
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := NewChanStream(make(chan int)).ToChannel(ctx)
+	cancel()
+
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatal("expected no elements from an idle source")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the channel to close after cancellation")
+	}
+	waitForGoroutines(t, before)
+}
@@ -3,6 +3,7 @@ package chain
 import (
 	"context"
 	"fmt"
+	"math"
 
 	lua "github.com/yuin/gopher-lua"
 )
@@ -15,12 +16,20 @@ func LuaLoader(L *lua.LState) int {
 	// Create methods table
 	methods := L.NewTable()
 	L.SetFuncs(methods, map[string]lua.LGFunction{
-		"map":      streamMap,
-		"filter":   streamFilter,
-		"reduce":   streamReduce,
-		"foreach":  streamForEach,
-		"collect":  streamCollect,
-		"parallel": streamParallel,
+		"map":            streamMap,
+		"filter":         streamFilter,
+		"reduce":         streamReduce,
+		"foreach":        streamForEach,
+		"collect":        streamCollect,
+		"collect_sorted": streamCollectSorted,
+		"parallel":       streamParallel,
+		"group_by":       streamGroupBy,
+		"batch":          streamBatch,
+		"window":         streamWindow,
+		"take":           streamTake,
+		"limit":          streamTake,
+		"skip":           streamSkip,
+		"distinct":       streamDistinct,
 	})
 
 	// Set methods
@@ -46,7 +55,9 @@ type streamUserData struct {
 	stream Stream[lua.LValue, lua.LValue]
 }
 
-// newStream creates a new stream from a Lua table
+// newStream creates a new stream from a Lua table. Elements may be any Lua
+// value, tables included; they are passed through by reference, so a table
+// keeps its identity and nested contents along the stream.
 func newStream(L *lua.LState) int {
 	tbl := L.CheckTable(1)
 	slice := make([]lua.LValue, 0, tbl.Len())
@@ -68,15 +79,23 @@ func newStream(L *lua.LState) int {
 	return 1
 }
 
-// streamMap implements Stream.Map
+// streamMap implements Stream.Map. An error raised by the Lua function fails
+// the stream, so that the terminal method returns it, and the function is not
+// called again afterwards. The callbacks of filter, reduce and foreach are
+// handled the same way.
 func streamMap(L *lua.LState) int {
 	ud := checkStream(L)
 	fn := L.CheckFunction(2)
+	in := asStream(ud.stream)
 
 	mapped := ud.stream.Map(func(v lua.LValue) lua.LValue {
+		if in.state.Err() != nil {
+			return lua.LNil
+		}
 		L.Push(fn)
 		L.Push(v)
 		if err := L.PCall(1, 1, nil); err != nil {
+			in.abort(err)
 			return lua.LNil
 		}
 		result := L.Get(-1)
@@ -95,11 +114,16 @@ func streamMap(L *lua.LState) int {
 func streamFilter(L *lua.LState) int {
 	ud := checkStream(L)
 	fn := L.CheckFunction(2)
+	in := asStream(ud.stream)
 
 	filtered := ud.stream.Filter(func(v lua.LValue) bool {
+		if in.state.Err() != nil {
+			return false
+		}
 		L.Push(fn)
 		L.Push(v)
 		if err := L.PCall(1, 1, nil); err != nil {
+			in.abort(err)
 			return false
 		}
 		result := lua.LVAsBool(L.Get(-1))
@@ -118,12 +142,17 @@ func streamFilter(L *lua.LState) int {
 func streamReduce(L *lua.LState) int {
 	ud := checkStream(L)
 	fn := L.CheckFunction(2)
+	in := asStream(ud.stream)
 
 	result, err := ud.stream.Reduce(func(a, b lua.LValue) lua.LValue {
+		if in.state.Err() != nil {
+			return lua.LNil
+		}
 		L.Push(fn)
 		L.Push(a)
 		L.Push(b)
 		if err := L.PCall(2, 1, nil); err != nil {
+			in.abort(err)
 			return lua.LNil
 		}
 		result := L.Get(-1)
@@ -145,13 +174,16 @@ func streamReduce(L *lua.LState) int {
 func streamForEach(L *lua.LState) int {
 	ud := checkStream(L)
 	fn := L.CheckFunction(2)
+	in := asStream(ud.stream)
 
 	err := ud.stream.ForEach(func(v lua.LValue) {
+		if in.state.Err() != nil {
+			return
+		}
 		L.Push(fn)
 		L.Push(v)
 		if err := L.PCall(1, 0, nil); err != nil {
-			// Handle error if needed
-			fmt.Println("Error in ForEach:", err)
+			in.abort(err)
 		}
 	})
 
@@ -183,6 +215,69 @@ func streamCollect(L *lua.LState) int {
 	return 1
 }
 
+// streamCollectSorted implements Stream.Collect on SortedBy, ordering the
+// elements by an optional Lua comparator returning whether a sorts before b.
+// Without one, numbers and strings sort in ascending order, and any other
+// element is reported as an error.
+func streamCollectSorted(L *lua.LState) int {
+	ud := checkStream(L)
+	fn := L.OptFunction(2, nil)
+	in := asStream(ud.stream)
+
+	less := func(a, b lua.LValue) bool {
+		if in.state.Err() != nil {
+			return false
+		}
+		if fn == nil {
+			less, err := luaDefaultLess(a, b)
+			if err != nil {
+				in.abort(err)
+			}
+			return less
+		}
+		L.Push(fn)
+		L.Push(a)
+		L.Push(b)
+		if err := L.PCall(2, 1, nil); err != nil {
+			in.abort(err)
+			return false
+		}
+		result := lua.LVAsBool(L.Get(-1))
+		L.Pop(1) // Clean up the stack
+		return result
+	}
+
+	result, err := SortedBy(ud.stream, less).Collect(context.Background())
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	tbl := L.CreateTable(len(result), 0)
+	for i, v := range result {
+		tbl.RawSetInt(i+1, v)
+	}
+
+	L.Push(tbl)
+	return 1
+}
+
+// luaDefaultLess compares two numbers or two strings the way Lua's < does
+func luaDefaultLess(a, b lua.LValue) (bool, error) {
+	switch x := a.(type) {
+	case lua.LNumber:
+		if y, ok := b.(lua.LNumber); ok {
+			return x < y, nil
+		}
+	case lua.LString:
+		if y, ok := b.(lua.LString); ok {
+			return x < y, nil
+		}
+	}
+	return false, fmt.Errorf("collect_sorted cannot compare %s with %s without a comparator", a.Type(), b.Type())
+}
+
 // streamParallel implements Stream.Parallel which enables concurrent processing
 // workers parameter determines the number of goroutines used for parallel execution
 func streamParallel(L *lua.LState) int {
@@ -219,6 +314,133 @@ func newGenerator(L *lua.LState) int {
 	return 1
 }
 
+// streamTake implements Stream.Take, also available as limit. Once n
+// elements have been produced the Lua callbacks upstream are no longer
+// invoked, which keeps scripts reading from infinite generators from running
+// away.
+func streamTake(L *lua.LState) int {
+	ud := checkStream(L)
+	n := L.CheckInt(2)
+
+	return pushStream(L, ud.stream.Take(n))
+}
+
+// streamSkip implements Stream.Skip
+func streamSkip(L *lua.LState) int {
+	ud := checkStream(L)
+	n := L.CheckInt(2)
+
+	return pushStream(L, ud.stream.Skip(n))
+}
+
+// streamDistinct implements Distinct. Values are compared like Lua's raw
+// equality: numbers and strings by value, tables and functions by identity.
+func streamDistinct(L *lua.LState) int {
+	ud := checkStream(L)
+
+	return pushStream(L, Distinct(ud.stream))
+}
+
+// streamBatch implements Batch, producing a stream of Lua arrays
+func streamBatch(L *lua.LState) int {
+	ud := checkStream(L)
+	size := L.CheckInt(2)
+	if size <= 0 {
+		L.ArgError(2, "batch size must be positive")
+		return 0
+	}
+
+	return pushStream(L, luaArrays(L, Batch(ud.stream, size)))
+}
+
+// streamWindow implements SlidingWindow, producing a stream of Lua arrays.
+// The step defaults to 1.
+func streamWindow(L *lua.LState) int {
+	ud := checkStream(L)
+	size := L.CheckInt(2)
+	step := L.OptInt(3, 1)
+	if size <= 0 {
+		L.ArgError(2, "window size must be positive")
+		return 0
+	}
+	if step <= 0 {
+		L.ArgError(3, "window step must be positive")
+		return 0
+	}
+
+	return pushStream(L, luaArrays(L, SlidingWindow(ud.stream, size, step)))
+}
+
+// luaArrays converts a stream of slices into a stream of Lua arrays
+func luaArrays(L *lua.LState, s Stream[[]lua.LValue, []lua.LValue]) Stream[lua.LValue, lua.LValue] {
+	return transform(s, func(values []lua.LValue) lua.LValue {
+		tbl := L.CreateTable(len(values), 0)
+		for i, v := range values {
+			tbl.RawSetInt(i+1, v)
+		}
+		return tbl
+	})
+}
+
+// pushStream pushes a new stream userdata sharing the metatable of the
+// stream in argument 1
+func pushStream(L *lua.LState, s Stream[lua.LValue, lua.LValue]) int {
+	ud := L.NewUserData()
+	ud.Value = &streamUserData{stream: s}
+	L.SetMetatable(ud, L.GetMetatable(L.Get(1)))
+	L.Push(ud)
+	return 1
+}
+
+// streamGroupBy implements GroupBy, returning a table that maps every key
+// produced by the Lua key function to an array of the matching elements
+func streamGroupBy(L *lua.LState) int {
+	ud := checkStream(L)
+	fn := L.CheckFunction(2)
+
+	var keyErr error
+	groups, err := GroupBy(context.Background(), ud.stream, func(v lua.LValue) lua.LValue {
+		if keyErr != nil {
+			return lua.LNil
+		}
+		L.Push(fn)
+		L.Push(v)
+		if err := L.PCall(1, 1, nil); err != nil {
+			keyErr = err
+			return lua.LNil
+		}
+		key := L.Get(-1)
+		L.Pop(1) // Clean up the stack
+		if key == lua.LNil {
+			keyErr = fmt.Errorf("group_by key must not be nil")
+		} else if n, ok := key.(lua.LNumber); ok && math.IsNaN(float64(n)) {
+			keyErr = fmt.Errorf("group_by key must not be NaN")
+		}
+		return key
+	})
+	if err == nil {
+		err = keyErr
+	}
+	if err != nil {
+		L.Push(lua.LNil)
+		L.Push(lua.LString(err.Error()))
+		return 2
+	}
+
+	// Convert groups to a Lua table of arrays
+	tbl := L.CreateTable(0, len(groups))
+	for key, values := range groups {
+		group := L.CreateTable(len(values), 0)
+		for i, v := range values {
+			group.RawSetInt(i+1, v)
+		}
+		tbl.RawSet(key, group)
+	}
+
+	L.Push(tbl)
+	return 1
+}
+
 // Helper function to check and get stream userdata
 func checkStream(L *lua.LState) *streamUserData {
 	ud := L.CheckUserData(1)
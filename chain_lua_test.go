@@ -3,6 +3,7 @@ package chain
 import (
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 
 	lua "github.com/yuin/gopher-lua"
@@ -244,3 +245,378 @@ func TestLuaStreamParallel(t *testing.T) {
 		t.Errorf("expected %v, got %v", expected, actual)
 	}
 }
+
+func TestLuaGroupBy(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		local items = {
+			{name = "apple", category = "fruit"},
+			{name = "carrot", category = "vegetable"},
+			{name = "banana", category = "fruit"},
+			{name = "leek", category = "vegetable"},
+			{name = "cherry", category = "fruit"},
+		}
+
+		groups = chain.new(items):group_by(function(x) return x.category end)
+
+		fruits = {}
+		for i, v in ipairs(groups.fruit) do
+			fruits[i] = v.name
+		end
+		vegetables = {}
+		for i, v in ipairs(groups.vegetable) do
+			vegetables[i] = v.name
+		end
+
+		local _, msg = chain.new({1, 2}):group_by(function(x) return nil end)
+		nil_key_error = msg
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	var fruits, vegetables []string
+	L.GetGlobal("fruits").(*lua.LTable).ForEach(func(_, v lua.LValue) {
+		fruits = append(fruits, v.String())
+	})
+	L.GetGlobal("vegetables").(*lua.LTable).ForEach(func(_, v lua.LValue) {
+		vegetables = append(vegetables, v.String())
+	})
+
+	if !reflect.DeepEqual(fruits, []string{"apple", "banana", "cherry"}) {
+		t.Errorf("unexpected fruit bucket: %v", fruits)
+	}
+	if !reflect.DeepEqual(vegetables, []string{"carrot", "leek"}) {
+		t.Errorf("unexpected vegetable bucket: %v", vegetables)
+	}
+	if msg := L.GetGlobal("nil_key_error"); msg.Type() != lua.LTString {
+		t.Errorf("expected an error message for a nil key, got %v", msg)
+	}
+}
+
+// luaArraysToInts converts a Lua array of arrays of numbers
+func luaArraysToInts(tbl *lua.LTable) [][]int {
+	var result [][]int
+	tbl.ForEach(func(_, value lua.LValue) {
+		var group []int
+		value.(*lua.LTable).ForEach(func(_, v lua.LValue) {
+			group = append(group, int(v.(lua.LNumber)))
+		})
+		result = append(result, group)
+	})
+	return result
+}
+
+func TestLuaBatch(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		local s = chain.new({1, 2, 3, 4, 5, 6, 7, 8, 9, 10})
+		batches = s:batch(3):collect()
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	batches := luaArraysToInts(L.GetGlobal("batches").(*lua.LTable))
+	expected := [][]int{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}, {10}}
+	if !reflect.DeepEqual(batches, expected) {
+		t.Errorf("expected %v, got %v", expected, batches)
+	}
+}
+
+func TestLuaWindow(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		windows = chain.new({1, 2, 3, 4, 5}):window(3, 1):collect()
+		hops = chain.new({1, 2, 3, 4, 5, 6, 7}):window(2, 3):collect()
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	windows := luaArraysToInts(L.GetGlobal("windows").(*lua.LTable))
+	expected := [][]int{{1, 2, 3}, {2, 3, 4}, {3, 4, 5}}
+	if !reflect.DeepEqual(windows, expected) {
+		t.Errorf("expected %v, got %v", expected, windows)
+	}
+
+	hops := luaArraysToInts(L.GetGlobal("hops").(*lua.LTable))
+	expected = [][]int{{1, 2}, {4, 5}}
+	if !reflect.DeepEqual(hops, expected) {
+		t.Errorf("expected %v, got %v", expected, hops)
+	}
+}
+
+func TestLuaLimit(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		calls = 0
+		local stream = chain.generator(function()
+			calls = calls + 1
+			return calls, true
+		end)
+
+		results = stream:limit(4):collect()
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	results := L.GetGlobal("results").(*lua.LTable)
+	if results.Len() != 4 {
+		t.Fatalf("expected 4 results, got %d", results.Len())
+	}
+	for i := 1; i <= 4; i++ {
+		if val := results.RawGetInt(i); val != lua.LNumber(i) {
+			t.Errorf("at index %d: expected %d, got %s", i, i, val)
+		}
+	}
+
+	if calls := L.GetGlobal("calls"); calls != lua.LNumber(4) {
+		t.Errorf("expected the generator to run 4 times, got %s", calls)
+	}
+}
+
+func TestLuaTakeSkipDistinct(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		local s = chain.new({1, 2, 2, 3, 4, 4, 4, 5, 6, 7, 8, 9, 10})
+
+		-- Chain the new methods with the existing ones
+		results = s
+			:distinct()
+			:filter(function(x) return x % 2 == 0 end)
+			:skip(1)
+			:map(function(x) return x * 10 end)
+			:take(3)
+			:collect()
+
+		words = chain.new({"a", "b", "a", "c", "b"}):distinct():collect()
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	var actual []int
+	L.GetGlobal("results").(*lua.LTable).ForEach(func(_, value lua.LValue) {
+		actual = append(actual, int(value.(lua.LNumber)))
+	})
+	if !reflect.DeepEqual(actual, []int{40, 60, 80}) {
+		t.Errorf("expected [40 60 80], got %v", actual)
+	}
+
+	var words []string
+	L.GetGlobal("words").(*lua.LTable).ForEach(func(_, value lua.LValue) {
+		words = append(words, value.String())
+	})
+	if !reflect.DeepEqual(words, []string{"a", "b", "c"}) {
+		t.Errorf("expected [a b c], got %v", words)
+	}
+}
+
+func TestLuaCallbackErrors(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		calls = 0
+		mapped, map_err = chain.new({1, 2, 3, 4, 5})
+			:map(function(x)
+				calls = calls + 1
+				if x == 3 then error("bad element " .. x) end
+				return x * 2
+			end)
+			:collect()
+
+		filtered, filter_err = chain.new({1, 2, 3})
+			:filter(function(x) return x.missing end)
+			:collect()
+
+		reduced, reduce_err = chain.new({1, 2, 3})
+			:reduce(function(a, b) error("cannot add") end)
+
+		foreach_err = chain.new({1, 2, 3})
+			:foreach(function(x) error("cannot visit") end)
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	if mapped := L.GetGlobal("mapped"); mapped != lua.LNil {
+		t.Errorf("expected collect to return nil, got %s", mapped)
+	}
+	if msg := L.GetGlobal("map_err").String(); !strings.Contains(msg, "bad element 3") {
+		t.Errorf("expected the map error message, got %q", msg)
+	}
+	if calls := L.GetGlobal("calls"); calls != lua.LNumber(3) {
+		t.Errorf("expected map to stop after the failing element, got %s calls", calls)
+	}
+
+	for _, name := range []string{"filtered", "reduced"} {
+		if v := L.GetGlobal(name); v != lua.LNil {
+			t.Errorf("expected %s to be nil, got %s", name, v)
+		}
+	}
+	for name, want := range map[string]string{
+		"filter_err":  "attempt to index",
+		"reduce_err":  "cannot add",
+		"foreach_err": "cannot visit",
+	} {
+		if msg := L.GetGlobal(name).String(); !strings.Contains(msg, want) {
+			t.Errorf("expected %s to contain %q, got %q", name, want, msg)
+		}
+	}
+}
+
+func TestLuaTableStream(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		local rows = {
+			{name = "ann", age = 31, tags = {"admin", "ops"}},
+			{name = "bob", age = 24, tags = {"dev"}},
+			{name = "cid", age = 45, tags = {}},
+		}
+
+		-- Rows flow through map and filter as tables, nested ones included
+		results = chain.new(rows)
+			:filter(function(row) return row.age > 30 end)
+			:map(function(row)
+				return {name = string.upper(row.name), first_tag = row.tags[1], row = row}
+			end)
+			:collect()
+
+		same_row = results[1].row == rows[1]
+
+		names = {}
+		chain.new(rows):foreach(function(row) names[#names + 1] = row.name end)
+
+		oldest = chain.new(rows):reduce(function(a, b)
+			if a.age >= b.age then return a end
+			return b
+		end)
+
+		strings_out = chain.new({"x", "y", "z"}):map(function(s) return s .. s end):collect()
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	results := L.GetGlobal("results").(*lua.LTable)
+	if results.Len() != 2 {
+		t.Fatalf("expected 2 results, got %d", results.Len())
+	}
+	first := results.RawGetInt(1).(*lua.LTable)
+	if name := first.RawGetString("name"); name != lua.LString("ANN") {
+		t.Errorf("expected ANN, got %s", name)
+	}
+	if tag := first.RawGetString("first_tag"); tag != lua.LString("admin") {
+		t.Errorf("expected admin, got %s", tag)
+	}
+	second := results.RawGetInt(2).(*lua.LTable)
+	if tag := second.RawGetString("first_tag"); tag != lua.LNil {
+		t.Errorf("expected no tag for cid, got %s", tag)
+	}
+	if L.GetGlobal("same_row") != lua.LTrue {
+		t.Errorf("expected nested tables to keep their identity")
+	}
+
+	var names []string
+	L.GetGlobal("names").(*lua.LTable).ForEach(func(_, v lua.LValue) {
+		names = append(names, v.String())
+	})
+	if !reflect.DeepEqual(names, []string{"ann", "bob", "cid"}) {
+		t.Errorf("expected [ann bob cid], got %v", names)
+	}
+
+	oldest := L.GetGlobal("oldest").(*lua.LTable)
+	if name := oldest.RawGetString("name"); name != lua.LString("cid") {
+		t.Errorf("expected cid to be the oldest, got %s", name)
+	}
+
+	var out []string
+	L.GetGlobal("strings_out").(*lua.LTable).ForEach(func(_, v lua.LValue) {
+		out = append(out, v.String())
+	})
+	if !reflect.DeepEqual(out, []string{"xx", "yy", "zz"}) {
+		t.Errorf("expected [xx yy zz], got %v", out)
+	}
+}
+
+func TestLuaCollectSorted(t *testing.T) {
+	L := setupLuaState(t)
+	defer L.Close()
+
+	err := L.DoString(`
+		ascending = chain.new({5, 3, 9, 1, 7, 2})
+			:parallel(3)
+			:map(function(x) return x * 2 end)
+			:collect_sorted()
+
+		descending = chain.new({5, 3, 9, 1})
+			:collect_sorted(function(a, b) return a > b end)
+
+		by_age = chain.new({{name = "ann", age = 31}, {name = "bob", age = 24}})
+			:collect_sorted(function(a, b) return a.age < b.age end)
+
+		words = chain.new({"pear", "apple", "fig"}):collect_sorted()
+
+		mixed, mixed_err = chain.new({1, "two"}):collect_sorted()
+	`)
+
+	if err != nil {
+		t.Fatalf("Failed to execute Lua code: %v", err)
+	}
+
+	toInts := func(name string) []int {
+		var out []int
+		L.GetGlobal(name).(*lua.LTable).ForEach(func(_, v lua.LValue) {
+			out = append(out, int(v.(lua.LNumber)))
+		})
+		return out
+	}
+	if got := toInts("ascending"); !reflect.DeepEqual(got, []int{2, 4, 6, 10, 14, 18}) {
+		t.Errorf("expected [2 4 6 10 14 18], got %v", got)
+	}
+	if got := toInts("descending"); !reflect.DeepEqual(got, []int{9, 5, 3, 1}) {
+		t.Errorf("expected [9 5 3 1], got %v", got)
+	}
+
+	byAge := L.GetGlobal("by_age").(*lua.LTable)
+	if name := byAge.RawGetInt(1).(*lua.LTable).RawGetString("name"); name != lua.LString("bob") {
+		t.Errorf("expected bob first, got %s", name)
+	}
+
+	var words []string
+	L.GetGlobal("words").(*lua.LTable).ForEach(func(_, v lua.LValue) {
+		words = append(words, v.String())
+	})
+	if !reflect.DeepEqual(words, []string{"apple", "fig", "pear"}) {
+		t.Errorf("expected [apple fig pear], got %v", words)
+	}
+
+	if mixed := L.GetGlobal("mixed"); mixed != lua.LNil {
+		t.Errorf("expected nil when elements cannot be compared, got %s", mixed)
+	}
+	if msg := L.GetGlobal("mixed_err").String(); !strings.Contains(msg, "cannot compare") {
+		t.Errorf("expected a comparison error, got %q", msg)
+	}
+}
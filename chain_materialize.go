@@ -0,0 +1,98 @@
+package chain
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Materialized holds the elements of a stream in memory so that they can be
+// replayed by any number of pipelines
+type Materialized[T any] struct {
+	data []T
+	pool *workerPool
+}
+
+// Materialize drains s into memory
+func Materialize[T any](ctx context.Context, s Stream[T, T]) (*Materialized[T], error) {
+	data, err := s.Collect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Materialized[T]{data: data}, nil
+}
+
+// Len returns the number of cached elements
+func (m *Materialized[T]) Len() int { return len(m.data) }
+
+// Stream returns a fresh stream replaying the cached elements
+func (m *Materialized[T]) Stream() Stream[T, T] {
+	s := asStream(NewSliceStream(m.data))
+	s.pool = m.pool
+	return s
+}
+
+// WithWorkerPool attaches a persistent worker pool to m. The parallel stages
+// of every replay then run on goroutines kept alive between runs instead of
+// spawning new ones, which pays off for iterative algorithms running the same
+// parallel pipeline over the data many times. Call Close to release the pool.
+func (m *Materialized[T]) WithWorkerPool() *Materialized[T] {
+	if m.pool == nil {
+		m.pool = newWorkerPool()
+	}
+	return m
+}
+
+// Close stops the goroutines of the attached worker pool, if any. Pipelines
+// started afterwards fall back to spawning their own goroutines.
+func (m *Materialized[T]) Close() {
+	if m.pool != nil {
+		m.pool.close()
+	}
+}
+
+// workerPool is a cache of goroutines that run tasks handed to them. It grows
+// on demand, so a task never waits for another one to finish, and idle
+// goroutines park until the next task or until the pool is closed.
+type workerPool struct {
+	mu      sync.RWMutex
+	tasks   chan func()
+	closed  bool
+	spawned atomic.Int64
+}
+
+func newWorkerPool() *workerPool {
+	return &workerPool{tasks: make(chan func())}
+}
+
+// run executes task on an idle pooled goroutine, or a new one if none is idle
+func (p *workerPool) run(task func()) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		go task()
+		return
+	}
+	select {
+	case p.tasks <- task:
+	default:
+		p.spawned.Add(1)
+		go p.work(task)
+	}
+}
+
+// work runs task and then keeps serving the pool until it is closed
+func (p *workerPool) work(task func()) {
+	for ok := true; ok; task, ok = <-p.tasks {
+		task()
+	}
+}
+
+func (p *workerPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+}
@@ -0,0 +1,83 @@
+package chain
+
+import (
+	"context"
+	"sort"
+	"testing"
+)
+
+func TestMaterialized(t *testing.T) {
+	m, err := Materialize(context.Background(), NewSliceStream([]int{1, 2, 3, 4, 5}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.Len() != 5 {
+		t.Errorf("expected 5 cached elements, got %d", m.Len())
+	}
+
+	m.WithWorkerPool()
+	defer m.Close()
+
+	for run := 0; run < 10; run++ {
+		result, err := m.Stream().Parallel(4).
+			Map(func(x int) int { return x * 2 }).
+			Filter(func(x int) bool { return x > 2 }).
+			Collect(context.Background())
+		if err != nil {
+			t.Fatalf("run %d: unexpected error: %v", run, err)
+		}
+		sort.Ints(result)
+		expected := []int{4, 6, 8, 10}
+		if len(result) != len(expected) {
+			t.Fatalf("run %d: expected %v, got %v", run, expected, result)
+		}
+		for i, v := range expected {
+			if result[i] != v {
+				t.Errorf("run %d at index %d: expected %d, got %d", run, i, v, result[i])
+			}
+		}
+	}
+
+	// Two parallel stages of four workers need at most eight goroutines,
+	// no matter how many times the pipeline runs
+	if spawned := m.pool.spawned.Load(); spawned > 8 {
+		t.Errorf("expected the pool to reuse goroutines, spawned %d", spawned)
+	}
+}
+
+func benchmarkMaterializedMap(b *testing.B, pooled bool) {
+	data := make([]int, 64)
+	for i := range data {
+		data[i] = i
+	}
+	m, err := Materialize(context.Background(), NewSliceStream(data))
+	if err != nil {
+		b.Fatal(err)
+	}
+	if pooled {
+		m.WithWorkerPool()
+		defer m.Close()
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for run := 0; run < 100; run++ {
+			_, err := m.Stream().Parallel(8).
+				Map(func(x int) int { return x * x }).
+				Collect(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if pooled {
+		b.ReportMetric(float64(m.pool.spawned.Load())/float64(b.N), "spawned/op")
+	} else {
+		// Without a pool every run spawns one goroutine per worker
+		b.ReportMetric(8*100, "spawned/op")
+	}
+}
+
+func BenchmarkMaterializedMap(b *testing.B) { benchmarkMaterializedMap(b, false) }
+
+func BenchmarkMaterializedMapPooled(b *testing.B) { benchmarkMaterializedMap(b, true) }
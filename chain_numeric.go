@@ -0,0 +1,87 @@
+package chain
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Number is satisfied by the built-in integer and floating-point types
+type Number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64
+}
+
+// Sum adds up the elements of s. Like Reduce it returns ErrEmptyStream for
+// an empty stream.
+func Sum[T Number](s Stream[T, T]) (T, error) {
+	return s.Reduce(func(a, b T) T { return a + b })
+}
+
+// Min returns the smallest element of s, and false if s is empty or fails
+func Min[T cmp.Ordered](s Stream[T, T]) (T, bool) {
+	v, err := s.Reduce(func(a, b T) T { return min(a, b) })
+	if err != nil {
+		var zero T
+		return zero, false
+	}
+	return v, true
+}
+
+// Max returns the largest element of s, and false if s is empty or fails
+func Max[T cmp.Ordered](s Stream[T, T]) (T, bool) {
+	v, err := s.Reduce(func(a, b T) T { return max(a, b) })
+	if err != nil {
+		var zero T
+		return zero, false
+	}
+	return v, true
+}
+
+// Normalize min-max scales all elements of s to the range [0, 1]. It has to
+// materialize the whole stream to find the bounds. If every element has the
+// same value there is no range to scale by and all results are 0.
+func Normalize[T Number](ctx context.Context, s Stream[T, T]) ([]float64, error) {
+	data, err := s.Collect(ctx)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	lo, hi := float64(data[0]), float64(data[0])
+	for _, v := range data[1:] {
+		lo = min(lo, float64(v))
+		hi = max(hi, float64(v))
+	}
+
+	result := make([]float64, len(data))
+	if hi == lo {
+		return result, nil
+	}
+	for i, v := range data {
+		result[i] = (float64(v) - lo) / (hi - lo)
+	}
+	return result, nil
+}
+
+// Histogram counts the elements of s falling into the buckets delimited by
+// the ascending bounds. The result has len(bounds)+1 counts: the first is
+// the underflow bucket of elements below bounds[0], count i holds elements
+// in [bounds[i-1], bounds[i]), and the last is the overflow bucket of
+// elements at or above the last bound. Elements are counted as they arrive.
+func Histogram[T Number](ctx context.Context, s Stream[T, T], bounds []T) ([]int, error) {
+	if !sort.SliceIsSorted(bounds, func(i, j int) bool { return bounds[i] < bounds[j] }) {
+		asStream(s).quit.fire()
+		return nil, fmt.Errorf("chain: histogram bounds must be sorted, got %v", bounds)
+	}
+
+	counts := make([]int, len(bounds)+1)
+	for v, err := range s.Seq2(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		counts[sort.Search(len(bounds), func(i int) bool { return v < bounds[i] })]++
+	}
+	return counts, nil
+}
@@ -0,0 +1,85 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	result, err := Normalize(context.Background(), NewSliceStream([]int{10, 20, 30}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []float64{0, 0.5, 1}) {
+		t.Errorf("expected [0 0.5 1], got %v", result)
+	}
+
+	result, err = Normalize(context.Background(), NewSliceStream([]float64{7, 7, 7}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []float64{0, 0, 0}) {
+		t.Errorf("expected all zeros for a constant stream, got %v", result)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	counts, err := Histogram(context.Background(), NewSliceStream([]int{5, 15, 25, 35}), []int{10, 20, 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(counts, []int{1, 1, 1, 1}) {
+		t.Errorf("expected [1 1 1 1], got %v", counts)
+	}
+
+	// Bounds are inclusive below, exclusive above
+	counts, err = Histogram(context.Background(), NewSliceStream([]float64{10, 10, 19.9, 20, 30, 31}), []float64{10, 20, 30})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(counts, []int{0, 3, 1, 2}) {
+		t.Errorf("expected [0 3 1 2], got %v", counts)
+	}
+
+	if _, err := Histogram(context.Background(), NewSliceStream([]int{1}), []int{20, 10}); err == nil {
+		t.Errorf("expected an error for unsorted bounds")
+	}
+}
+
+func TestSumMinMax(t *testing.T) {
+	ints := []int{3, -1, 4, 1, 5}
+	if sum, err := Sum(NewSliceStream(ints)); err != nil || sum != 12 {
+		t.Errorf("expected (12, nil), got (%d, %v)", sum, err)
+	}
+	if v, ok := Min(NewSliceStream(ints)); !ok || v != -1 {
+		t.Errorf("expected (-1, true), got (%d, %v)", v, ok)
+	}
+	if v, ok := Max(NewSliceStream(ints)); !ok || v != 5 {
+		t.Errorf("expected (5, true), got (%d, %v)", v, ok)
+	}
+
+	floats := []float64{1.5, 2.25, -0.5}
+	if sum, err := Sum(NewSliceStream(floats)); err != nil || sum != 3.25 {
+		t.Errorf("expected (3.25, nil), got (%v, %v)", sum, err)
+	}
+	if v, ok := Min(NewSliceStream(floats)); !ok || v != -0.5 {
+		t.Errorf("expected (-0.5, true), got (%v, %v)", v, ok)
+	}
+	if v, ok := Max(NewSliceStream(floats)); !ok || v != 2.25 {
+		t.Errorf("expected (2.25, true), got (%v, %v)", v, ok)
+	}
+}
+
+func TestSumMinMaxEmpty(t *testing.T) {
+	if sum, err := Sum(NewSliceStream([]int{})); !errors.Is(err, ErrEmptyStream) || sum != 0 {
+		t.Errorf("expected (0, ErrEmptyStream), got (%d, %v)", sum, err)
+	}
+	if v, ok := Min(NewSliceStream([]float64{})); ok || v != 0 {
+		t.Errorf("expected (0, false), got (%v, %v)", v, ok)
+	}
+	if v, ok := Max(NewSliceStream([]string{})); ok || v != "" {
+		t.Errorf("expected (\"\", false), got (%q, %v)", v, ok)
+	}
+}
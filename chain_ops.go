@@ -0,0 +1,891 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// transform applies fn to every element, changing the element type as the
+// Map method cannot
+func transform[T any, R any](s Stream[T, T], fn func(T) R) Stream[R, R] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan R, in.workers)
+
+	go func() {
+		defer close(out)
+		in.fanOut("transform", func(int) {
+			for item := range receive(source, in.quit) {
+				if !send(out, fn(item), in.quit) {
+					return
+				}
+			}
+		})
+	}()
+
+	return derive(in, out)
+}
+
+// MapErr transforms elements with a fallible fn. The first error stops the
+// pipeline and is returned by the terminal operation; elements that are
+// already in flight may still be processed, but nothing more is emitted.
+func MapErr[T any, R any](s Stream[T, T], fn func(T) (R, error)) Stream[R, R] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan R, in.workers)
+
+	go func() {
+		defer close(out)
+		in.fanOut("MapErr", func(int) {
+			for item := range receive(source, in.quit) {
+				result, err := fn(item)
+				if err != nil {
+					in.abort(err)
+					return
+				}
+				if !send(out, result, in.quit) {
+					return
+				}
+			}
+		})
+	}()
+
+	return derive(in, out)
+}
+
+// FlatMap expands every element into the zero or more elements returned by
+// fn. Under Parallel the expansions are computed concurrently but emitted in
+// the order of the elements they came from, each one kept together. To do so
+// expansions that finish early are buffered until all earlier ones have been
+// emitted, so a single slow element holds back the output, and the buffer
+// grows with the number of elements processed in the meantime.
+func FlatMap[T any, R any](s Stream[T, T], fn func(T) []R) Stream[R, R] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan R, in.workers)
+
+	emit := func(expansion []R) bool {
+		for _, item := range expansion {
+			if !send(out, item, in.quit) {
+				return false
+			}
+		}
+		return true
+	}
+
+	go func() {
+		defer close(out)
+
+		if in.workers <= 1 {
+			for item := range receive(source, in.quit) {
+				if !emit(fn(item)) {
+					return
+				}
+			}
+			return
+		}
+
+		fanOutOrdered(in, "FlatMap", source, func(_ int, item T) []R {
+			return fn(item)
+		}, emit)
+	}()
+
+	return derive(in, out)
+}
+
+// StateMachine threads a state through the elements of s: step receives the
+// current state and an element and returns the next state, plus an output
+// and whether to emit it. The state starts as initial. step is never called
+// concurrently, whatever the parallel setting, and is not told when the
+// stream ends, so output depending on a final state has to be derived from
+// an element that marks the end.
+func StateMachine[T any, S any, R any](s Stream[T, T], initial S, step func(S, T) (S, R, bool)) Stream[R, R] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan R, 1)
+
+	go func() {
+		defer close(out)
+
+		state := initial
+		for item := range receive(source, in.quit) {
+			var result R
+			var emit bool
+			state, result, emit = step(state, item)
+			if emit && !send(out, result, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// Scan emits the running accumulation of s: each element is folded into the
+// accumulator with fn, starting from init, and the updated accumulator is
+// emitted. fn runs sequentially whatever the parallel setting, but under
+// Parallel the elements reach it in the order the parallel stages produced
+// them; use OrderedParallel when that order matters.
+func Scan[T any, R any](s Stream[T, T], init R, fn func(R, T) R) Stream[R, R] {
+	return StateMachine(s, init, func(acc R, item T) (R, R, bool) {
+		acc = fn(acc, item)
+		return acc, acc, true
+	})
+}
+
+// circuitBreaker tracks consecutive failures and opens for a cooldown period
+// once the threshold is reached
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+// allow reports whether a call may be attempted now
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures < b.threshold || !time.Now().Before(b.openUntil)
+}
+
+// record updates the breaker with the outcome of an attempted call
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// MapCircuitBreaker transforms elements with a fallible fn, protecting whatever
+// fn calls from being hammered while it is failing. After failureThreshold
+// consecutive failures the breaker opens and elements are dropped without
+// calling fn until cooldown has elapsed; the next call then decides whether
+// the breaker closes again (success) or reopens (failure). Elements for which
+// fn fails are dropped as well. Every dropped element is passed to onReject,
+// if it is not nil, with the error of fn or with ErrCircuitOpen.
+func MapCircuitBreaker[T any, R any](s Stream[T, T], fn func(T) (R, error), failureThreshold int, cooldown time.Duration, onReject func(T, error)) Stream[R, R] {
+	in := asStream(s)
+	if failureThreshold <= 0 {
+		failureThreshold = 1
+	}
+	breaker := &circuitBreaker{threshold: failureThreshold, cooldown: cooldown}
+	source := in.channel()
+	out := make(chan R, in.workers)
+
+	go func() {
+		defer close(out)
+		in.fanOut("MapCircuitBreaker", func(int) {
+			for item := range receive(source, in.quit) {
+				if !breaker.allow() {
+					if onReject != nil {
+						onReject(item, ErrCircuitOpen)
+					}
+					continue
+				}
+				result, err := fn(item)
+				breaker.record(err)
+				if err != nil {
+					if onReject != nil {
+						onReject(item, err)
+					}
+					continue
+				}
+				if !send(out, result, in.quit) {
+					return
+				}
+			}
+		})
+	}()
+
+	return derive(in, out)
+}
+
+// WithMaxDuration implements Stream.WithMaxDuration. The clock starts when it
+// is called, so apply it right after constructing the source. Once d has
+// elapsed the pipeline is torn down and its terminal operation returns an
+// error matching both ErrDeadline and context.DeadlineExceeded. Like
+// WithContext, it also stops a lazy pipeline, which has no goroutines to
+// tear down.
+func (s *stream[T, R]) WithMaxDuration(d time.Duration) Stream[T, R] {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	go func() {
+		<-s.quit.done()
+		cancel()
+	}()
+	return bindContext(ctx, s)
+}
+
+// Take implements Stream.Take. The stages after Take get a quit signal of
+// their own, so that stopping the stages before it once n elements have been
+// taken doesn't also interrupt the ones still delivering them downstream.
+func (s *stream[T, R]) Take(n int) Stream[T, R] {
+	down := newSignal(s.quit)
+
+	// An iterator-backed stream is wrapped directly, so the source is never
+	// asked for more than n elements
+	if s.source == nil && s.next != nil {
+		next := s.next
+		taken := 0
+		return &stream[T, R]{
+			next: func() (T, bool) {
+				if taken >= n {
+					s.quit.fire()
+					var zero T
+					return zero, false
+				}
+				taken++
+				return next()
+			},
+			size:    s.size,
+			workers: s.workers,
+			lazy:    s.lazy,
+			pool:    s.pool,
+			profile: s.profile,
+			trace:   s.trace,
+			ordered: s.ordered,
+			buffer:  s.buffer,
+			state:   s.state,
+			quit:    down,
+		}
+	}
+
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+		defer s.quit.fire()
+
+		for taken := 0; taken < n; taken++ {
+			item, ok := recv(source, s.quit)
+			if !ok || !send(out, item, down) {
+				return
+			}
+		}
+	}()
+
+	taken := s.forward(out)
+	taken.quit = down
+	return taken
+}
+
+// Skip implements Stream.Skip. Skipping more elements than the stream has
+// yields an empty stream, and skipping n <= 0 elements none.
+func (s *stream[T, R]) Skip(n int) Stream[T, R] {
+	if n <= 0 {
+		return s
+	}
+
+	if s.source == nil && s.next != nil {
+		next := s.next
+		skipped := false
+		return &stream[T, R]{
+			next: func() (T, bool) {
+				if !skipped {
+					skipped = true
+					for i := 0; i < n; i++ {
+						if _, ok := next(); !ok {
+							var zero T
+							return zero, false
+						}
+					}
+				}
+				return next()
+			},
+			size:    s.size,
+			workers: s.workers,
+			lazy:    s.lazy,
+			pool:    s.pool,
+			profile: s.profile,
+			trace:   s.trace,
+			ordered: s.ordered,
+			buffer:  s.buffer,
+			state:   s.state,
+			quit:    s.quit,
+		}
+	}
+
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		for i := 0; i < n; i++ {
+			if _, ok := recv(source, s.quit); !ok {
+				return
+			}
+		}
+		for item := range receive(source, s.quit) {
+			if !send(out, item, s.quit) {
+				return
+			}
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// TakeWhile implements Stream.TakeWhile. Like Take, it gives the stages
+// after it a quit signal of their own.
+func (s *stream[T, R]) TakeWhile(fn func(T) bool) Stream[T, R] {
+	down := newSignal(s.quit)
+
+	if s.source == nil && s.next != nil {
+		next := s.next
+		done := false
+		return &stream[T, R]{
+			next: func() (T, bool) {
+				var zero T
+				if done {
+					return zero, false
+				}
+				item, ok := next()
+				if ok && fn(item) {
+					return item, true
+				}
+				done = true
+				s.quit.fire()
+				return zero, false
+			},
+			size:    s.size,
+			workers: s.workers,
+			lazy:    s.lazy,
+			pool:    s.pool,
+			profile: s.profile,
+			trace:   s.trace,
+			ordered: s.ordered,
+			buffer:  s.buffer,
+			state:   s.state,
+			quit:    down,
+		}
+	}
+
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+		defer s.quit.fire()
+
+		for item := range receive(source, s.quit) {
+			if !fn(item) || !send(out, item, down) {
+				return
+			}
+		}
+	}()
+
+	taken := s.forward(out)
+	taken.quit = down
+	return taken
+}
+
+// DropWhile implements Stream.DropWhile. fn is not called again once it has
+// returned false.
+func (s *stream[T, R]) DropWhile(fn func(T) bool) Stream[T, R] {
+	if s.source == nil && s.next != nil {
+		next := s.next
+		dropping := true
+		return &stream[T, R]{
+			next: func() (T, bool) {
+				for {
+					item, ok := next()
+					if !ok || !dropping || !fn(item) {
+						dropping = false
+						return item, ok
+					}
+				}
+			},
+			size:    s.size,
+			workers: s.workers,
+			lazy:    s.lazy,
+			pool:    s.pool,
+			profile: s.profile,
+			trace:   s.trace,
+			ordered: s.ordered,
+			buffer:  s.buffer,
+			state:   s.state,
+			quit:    s.quit,
+		}
+	}
+
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		dropping := true
+		for item := range receive(source, s.quit) {
+			if dropping && fn(item) {
+				continue
+			}
+			dropping = false
+			if !send(out, item, s.quit) {
+				return
+			}
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// Debounce implements Stream.Debounce. Each incoming element restarts the
+// quiet period; when it elapses the latest element is emitted. A pending
+// element is flushed when the source ends.
+func (s *stream[T, R]) Debounce(d time.Duration) Stream[T, R] {
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		timer := time.NewTimer(d)
+		timer.Stop()
+		defer timer.Stop()
+
+		var latest T
+		pending := false
+		for {
+			select {
+			case item, ok := <-source:
+				if !ok {
+					if pending {
+						send(out, latest, s.quit)
+					}
+					return
+				}
+				latest, pending = item, true
+				timer.Reset(d)
+			case <-s.quit.done():
+				return
+			case <-timer.C:
+				if pending {
+					pending = false
+					if !send(out, latest, s.quit) {
+						return
+					}
+				}
+			case <-s.quit.done():
+				return
+			}
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// Peek implements Stream.Peek. fn is called from a single goroutine, in the
+// order in which elements reach this stage, even under Parallel. A panic in
+// fn fails the pipeline like one in Map.
+func (s *stream[T, R]) Peek(fn func(T)) Stream[T, R] {
+	if s.pulling() {
+		next := s.iter()
+		return &stream[T, R]{
+			next: func() (item T, ok bool) {
+				defer s.recoverPull("Peek", &ok)
+				if item, ok = next(); ok {
+					fn(item)
+				}
+				return item, ok
+			},
+			workers: s.workers,
+			lazy:    true,
+			pool:    s.pool,
+			profile: s.profile,
+			trace:   s.trace,
+			ordered: s.ordered,
+			buffer:  s.buffer,
+			state:   s.state,
+			quit:    s.quit,
+		}
+	}
+
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+		defer s.recoverPanic("Peek")
+
+		for item := range receive(source, s.quit) {
+			fn(item)
+			if !send(out, item, s.quit) {
+				return
+			}
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// SampleLast implements Stream.SampleLast. Intervals in which nothing
+// arrived emit nothing. The latest element of the interval in progress when
+// the source ends is flushed. It panics if d is not positive.
+func (s *stream[T, R]) SampleLast(d time.Duration) Stream[T, R] {
+	if d <= 0 {
+		panic("chain: SampleLast interval must be positive")
+	}
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+
+		var latest T
+		pending := false
+		for {
+			select {
+			case item, ok := <-source:
+				if !ok {
+					if pending {
+						send(out, latest, s.quit)
+					}
+					return
+				}
+				latest, pending = item, true
+			case <-s.quit.done():
+				return
+			case <-ticker.C:
+				if !pending {
+					continue
+				}
+				pending = false
+				if !send(out, latest, s.quit) {
+					return
+				}
+			case <-s.quit.done():
+				return
+			}
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// WithAck implements Stream.WithAck. An element is acked once the next stage
+// asks for the one after it, and the last one once the stream ends; an ack
+// error fails the pipeline.
+func (s *stream[T, R]) WithAck(ack func(T) error) Stream[T, R] {
+	source := s.channel()
+	out := make(chan T)
+
+	go func() {
+		var pending T
+		received := false
+		// settle acks the element the next stage has finished with
+		settle := func() bool {
+			if err := ack(pending); err != nil {
+				s.abort(err)
+				return false
+			}
+			return true
+		}
+
+		for item := range receive(source, s.quit) {
+			if !send(out, item, s.quit) {
+				break
+			}
+			if received && !settle() {
+				break
+			}
+			pending, received = item, true
+		}
+		close(out)
+
+		// The terminal operation fires quit once it returns
+		<-s.quit.done()
+		if received && s.state.reachedEnd() && s.state.Err() == nil {
+			settle()
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// ThrottleBurst implements Stream.ThrottleBurst with a token bucket holding
+// up to burst tokens, refilled at one token per rate. Each element takes a
+// token, waiting for one if the bucket is empty; the bucket starts full. It
+// panics if rate or burst is not positive.
+func (s *stream[T, R]) ThrottleBurst(rate time.Duration, burst int) Stream[T, R] {
+	if rate <= 0 || burst <= 0 {
+		panic("chain: ThrottleBurst rate and burst must be positive")
+	}
+	source := s.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		// The bucket is tracked as the time at which it will be full again:
+		// an element may pass as long as that is at most burst-1 tokens ahead
+		var full time.Time
+		allowance := time.Duration(burst-1) * rate
+		for item := range receive(source, s.quit) {
+			now := time.Now()
+			if full.Before(now) {
+				full = now
+			}
+			if wait := full.Sub(now) - allowance; wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-s.quit.done():
+					timer.Stop()
+					return
+				}
+			}
+			full = full.Add(rate)
+			if !send(out, item, s.quit) {
+				return
+			}
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// WithHeartbeat implements Stream.WithHeartbeat. Elements pass through
+// unchanged; whenever d elapses without an element, beat is called. Beating
+// stops as soon as the source ends.
+func (s *stream[T, R]) WithHeartbeat(d time.Duration, beat func()) Stream[T, R] {
+	return s.onIdle(d, func(chan<- T) bool {
+		beat()
+		return true
+	})
+}
+
+// WithKeepaliveElement implements Stream.WithKeepaliveElement. Elements pass
+// through unchanged; whenever d elapses without an element, ka is emitted.
+// Consumers tell keepalives from real data by comparing against ka, so pick a
+// value that cannot occur in the stream.
+func (s *stream[T, R]) WithKeepaliveElement(d time.Duration, ka T) Stream[T, R] {
+	return s.onIdle(d, func(out chan<- T) bool {
+		return send(out, ka, s.quit)
+	})
+}
+
+// onIdle forwards elements unchanged and calls tick every time d passes
+// without one, until the source ends or tick reports false
+func (s *stream[T, R]) onIdle(d time.Duration, tick func(out chan<- T) bool) Stream[T, R] {
+	source := s.channel()
+	out := make(chan T, s.workers)
+
+	go func() {
+		defer close(out)
+
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+
+		for {
+			select {
+			case item, ok := <-source:
+				if !ok {
+					return
+				}
+				if !send(out, item, s.quit) {
+					return
+				}
+				timer.Reset(d)
+			case <-s.quit.done():
+				return
+			case <-timer.C:
+				if !tick(out) {
+					return
+				}
+				timer.Reset(d)
+			case <-s.quit.done():
+				return
+			}
+		}
+	}()
+
+	return s.forward(out)
+}
+
+// Project copies the named fields of each struct element into a new R, which
+// is either a struct with fields of the same names or a map[string]any. T may
+// be a struct or a pointer to one. Fields missing from T or R, or whose types
+// do not match, fail the pipeline with a descriptive error.
+func Project[T any, R any](s Stream[T, T], fields ...string) Stream[R, R] {
+	in := asStream(s)
+	project, err := projection[T, R](fields)
+	if err != nil {
+		in.abort(err)
+	}
+
+	source := in.channel()
+	out := make(chan R, in.workers)
+
+	go func() {
+		defer close(out)
+		if err != nil {
+			return
+		}
+		in.fanOut("Project", func(int) {
+			for item := range receive(source, in.quit) {
+				result, err := project(item)
+				if err != nil {
+					in.abort(err)
+					return
+				}
+				if !send(out, result, in.quit) {
+					return
+				}
+			}
+		})
+	}()
+
+	return derive(in, out)
+}
+
+// projection builds the function copying fields from T into R
+func projection[T any, R any](fields []string) (func(T) (R, error), error) {
+	srcType := reflect.TypeOf((*T)(nil)).Elem()
+	isPtr := srcType.Kind() == reflect.Pointer
+	if isPtr {
+		srcType = srcType.Elem()
+	}
+	if srcType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("chain: Project source type %s is not a struct", srcType)
+	}
+	for _, name := range fields {
+		f, ok := srcType.FieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("chain: Project source type %s has no field %q", srcType, name)
+		}
+		if !f.IsExported() {
+			return nil, fmt.Errorf("chain: Project field %q of %s is not exported", name, srcType)
+		}
+	}
+
+	source := func(item T) (reflect.Value, error) {
+		v := reflect.ValueOf(&item).Elem()
+		if isPtr {
+			if v.IsNil() {
+				return v, fmt.Errorf("chain: Project cannot project a nil %s", v.Type())
+			}
+			v = v.Elem()
+		}
+		return v, nil
+	}
+
+	dstType := reflect.TypeOf((*R)(nil)).Elem()
+	if dstType == reflect.TypeOf(map[string]any(nil)) {
+		return func(item T) (R, error) {
+			var result R
+			v, err := source(item)
+			if err != nil {
+				return result, err
+			}
+			m := make(map[string]any, len(fields))
+			for _, name := range fields {
+				m[name] = v.FieldByName(name).Interface()
+			}
+			reflect.ValueOf(&result).Elem().Set(reflect.ValueOf(m))
+			return result, nil
+		}, nil
+	}
+
+	if dstType.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("chain: Project target type %s is neither a struct nor map[string]any", dstType)
+	}
+	for _, name := range fields {
+		dst, ok := dstType.FieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("chain: Project target type %s has no field %q", dstType, name)
+		}
+		if !dst.IsExported() {
+			return nil, fmt.Errorf("chain: Project field %q of %s is not exported", name, dstType)
+		}
+		src, _ := srcType.FieldByName(name)
+		if !src.Type.AssignableTo(dst.Type) {
+			return nil, fmt.Errorf("chain: Project field %q has type %s in %s but %s in %s",
+				name, src.Type, srcType, dst.Type, dstType)
+		}
+	}
+	return func(item T) (R, error) {
+		var result R
+		v, err := source(item)
+		if err != nil {
+			return result, err
+		}
+		dst := reflect.ValueOf(&result).Elem()
+		for _, name := range fields {
+			dst.FieldByName(name).Set(v.FieldByName(name))
+		}
+		return result, nil
+	}, nil
+}
+
+// Cast type-asserts every element of an untyped stream to R. An element that
+// is not an R fails the pipeline with an error naming its dynamic type; use
+// Filter beforehand to drop such elements instead.
+func Cast[R any](s Stream[any, any]) Stream[R, R] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan R, in.workers)
+
+	go func() {
+		defer close(out)
+		for item := range receive(source, in.quit) {
+			result, ok := item.(R)
+			if !ok {
+				in.abort(fmt.Errorf("chain: Cast cannot convert %T to %s", item, reflect.TypeOf((*R)(nil)).Elem()))
+				return
+			}
+			if !send(out, result, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// CoalesceBy merges runs of consecutive elements sharing the same key into a
+// single element using merge. Elements with equal keys that are not adjacent
+// are left alone. The stage runs sequentially to keep adjacency meaningful.
+func CoalesceBy[T any, K comparable](s Stream[T, T], keyFn func(T) K, merge func(a, b T) T) Stream[T, T] {
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		var acc T
+		var key K
+		pending := false
+		for item := range receive(source, in.quit) {
+			k := keyFn(item)
+			if pending && k == key {
+				acc = merge(acc, item)
+				continue
+			}
+			if pending && !send(out, acc, in.quit) {
+				return
+			}
+			acc, key, pending = item, k, true
+		}
+		if pending {
+			send(out, acc, in.quit)
+		}
+	}()
+
+	return in.forward(out)
+}
@@ -0,0 +1,815 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMapCircuitBreaker(t *testing.T) {
+	var calls atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+
+	errUnavailable := errors.New("service unavailable")
+	var failed, open atomic.Int32
+
+	ch := make(chan int)
+	stream := MapCircuitBreaker(NewChanStream(ch), func(x int) (int, error) {
+		calls.Add(1)
+		if failing.Load() {
+			return 0, errUnavailable
+		}
+		return x * 10, nil
+	}, 3, 50*time.Millisecond, func(_ int, err error) {
+		switch {
+		case errors.Is(err, errUnavailable):
+			failed.Add(1)
+		case errors.Is(err, ErrCircuitOpen):
+			open.Add(1)
+		default:
+			t.Errorf("unexpected rejection: %v", err)
+		}
+	})
+
+	done := make(chan []int, 1)
+	go func() {
+		result, err := stream.Collect(context.Background())
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		done <- result
+	}()
+
+	// The breaker opens after three failures and stops calling fn
+	for i := 0; i < 10; i++ {
+		ch <- i
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := calls.Load(); got != 3 {
+		t.Fatalf("expected fn to be called 3 times before opening, got %d", got)
+	}
+
+	// Once the cooldown has elapsed the breaker lets calls through again
+	failing.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for i := 1; i <= 5; i++ {
+		ch <- i
+	}
+	close(ch)
+
+	result := <-done
+	if got := calls.Load(); got != 8 {
+		t.Errorf("expected fn to be called 8 times in total, got %d", got)
+	}
+	// Every element of the first batch was rejected, for one reason or the other
+	if f, o := failed.Load(), open.Load(); f != 3 || o != 7 {
+		t.Errorf("expected 3 failures and 7 elements rejected while open, got %d and %d", f, o)
+	}
+	expected := []int{10, 20, 30, 40, 50}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	for i, v := range expected {
+		if result[i] != v {
+			t.Errorf("at index %d: expected %d, got %d", i, v, result[i])
+		}
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		// A burst followed by a pause, then a second burst
+		for i := 1; i <= 5; i++ {
+			ch <- i
+			time.Sleep(time.Millisecond)
+		}
+		time.Sleep(100 * time.Millisecond)
+		for i := 6; i <= 8; i++ {
+			ch <- i
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	result, err := NewChanStream(ch).Debounce(40 * time.Millisecond).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []int{5, 8}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	for i, v := range expected {
+		if result[i] != v {
+			t.Errorf("at index %d: expected %d, got %d", i, v, result[i])
+		}
+	}
+}
+
+func TestWithHeartbeat(t *testing.T) {
+	var beats atomic.Int32
+	count := 0
+	slow := Generator(func() (int, bool) {
+		if count > 0 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		count++
+		return count, count <= 3
+	})
+
+	result, err := slow.WithHeartbeat(10*time.Millisecond, func() {
+		beats.Add(1)
+	}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 3 {
+		t.Errorf("expected 3 elements, got %v", result)
+	}
+
+	got := beats.Load()
+	if got < 4 {
+		t.Errorf("expected heartbeats during the gaps, got %d", got)
+	}
+
+	// No more beats once the stream has ended
+	time.Sleep(50 * time.Millisecond)
+	if after := beats.Load(); after != got {
+		t.Errorf("expected heartbeat to stop after the stream ended, got %d more", after-got)
+	}
+}
+
+func TestProject(t *testing.T) {
+	users := []User{{Age: 25, Score: 80}, {Age: 30, Score: 95}}
+
+	type ScoreOnly struct {
+		Score int
+	}
+	scores, err := Project[User, ScoreOnly](NewSliceStream(users), "Score").Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []ScoreOnly{{Score: 80}, {Score: 95}}
+	if !reflect.DeepEqual(scores, expected) {
+		t.Errorf("expected %v, got %v", expected, scores)
+	}
+
+	maps, err := Project[*User, map[string]any](NewSliceStream([]*User{&users[0]}), "Score").
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(maps) != 1 || !reflect.DeepEqual(maps[0], map[string]any{"Score": 80}) {
+		t.Errorf("expected [map[Score:80]], got %v", maps)
+	}
+}
+
+func TestProjectErrors(t *testing.T) {
+	users := []User{{Age: 25, Score: 80}}
+
+	type Missing struct {
+		Name string
+	}
+	_, err := Project[User, Missing](NewSliceStream(users), "Name").Collect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `no field "Name"`) {
+		t.Errorf("expected missing field error, got %v", err)
+	}
+
+	type Mismatch struct {
+		Score string
+	}
+	_, err = Project[User, Mismatch](NewSliceStream(users), "Score").Collect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `field "Score" has type int`) {
+		t.Errorf("expected type mismatch error, got %v", err)
+	}
+
+	type player struct {
+		Name  string
+		score int
+	}
+	players := NewSliceStream([]player{{Name: "ann", score: 3}})
+	_, err = Project[player, map[string]any](players, "score").Collect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), `field "score" of chain.player is not exported`) {
+		t.Errorf("expected unexported source field error, got %v", err)
+	}
+}
+
+func TestCast(t *testing.T) {
+	result, err := Cast[int](NewSliceStream([]any{1, 2, 3})).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", result)
+	}
+
+	_, err = Cast[int](NewSliceStream([]any{1, "two", 3})).Collect(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "cannot convert string to int") {
+		t.Errorf("expected a conversion error, got %v", err)
+	}
+}
+
+func TestCoalesceBy(t *testing.T) {
+	type metric struct {
+		At    int
+		Value int
+	}
+	input := []metric{{1, 1}, {1, 2}, {2, 5}, {3, 1}, {3, 1}, {3, 1}, {1, 7}}
+
+	result, err := CoalesceBy(NewSliceStream(input),
+		func(m metric) int { return m.At },
+		func(a, b metric) metric { return metric{a.At, a.Value + b.Value} },
+	).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The trailing {1, 7} is not adjacent to the first run and stays separate
+	expected := []metric{{1, 3}, {2, 5}, {3, 3}, {1, 7}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestWithMaxDuration(t *testing.T) {
+	start := time.Now()
+	_, err := Generator(func() (int, bool) {
+		time.Sleep(time.Millisecond)
+		return 1, true
+	}).WithMaxDuration(50 * time.Millisecond).
+		Map(func(x int) int { return x * 2 }).
+		Collect(context.Background())
+
+	if !errors.Is(err, ErrDeadline) {
+		t.Errorf("expected ErrDeadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Errorf("expected the pipeline to stop near the deadline, took %v", elapsed)
+	}
+
+	// A pipeline finishing in time is not affected
+	result, err := NewSliceStream([]int{1, 2, 3}).WithMaxDuration(time.Second).Collect(context.Background())
+	if err != nil || len(result) != 3 {
+		t.Errorf("expected [1 2 3], got %v (err %v)", result, err)
+	}
+
+	// A lazy pipeline over an infinite generator stops too
+	start = time.Now()
+	_, err = Generator(func() (int, bool) { return 1, true }).Lazy().
+		WithMaxDuration(50 * time.Millisecond).
+		Map(func(x int) int { return x }).
+		Count(context.Background())
+	if !errors.Is(err, ErrDeadline) {
+		t.Errorf("expected ErrDeadline, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the lazy pipeline to stop near the deadline, took %v", elapsed)
+	}
+}
+
+func TestWithKeepaliveElement(t *testing.T) {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		ch <- 1
+		time.Sleep(60 * time.Millisecond)
+		ch <- 2
+		ch <- 3
+	}()
+
+	result, err := NewChanStream(ch).WithKeepaliveElement(20*time.Millisecond, -1).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var data []int
+	keepalives := 0
+	for _, v := range result {
+		if v == -1 {
+			keepalives++
+			continue
+		}
+		data = append(data, v)
+	}
+	if !reflect.DeepEqual(data, []int{1, 2, 3}) {
+		t.Errorf("expected real elements [1 2 3], got %v", data)
+	}
+	if keepalives < 1 {
+		t.Errorf("expected keepalives during the idle gap, got %v", result)
+	}
+	if result[0] != 1 || result[len(result)-1] != 3 {
+		t.Errorf("expected keepalives only in the gap, got %v", result)
+	}
+}
+
+func TestFlatMapParallelOrdered(t *testing.T) {
+	// Earlier elements take longer, so workers finish them last
+	stream := NewSliceStream([]int{1, 2, 3, 4, 5, 6}).Parallel(3)
+	result, err := FlatMap(stream, func(x int) []int {
+		time.Sleep(time.Duration(7-x) * 5 * time.Millisecond)
+		return []int{x * 10, x*10 + 1, x*10 + 2}
+	}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var expected []int
+	for x := 1; x <= 6; x++ {
+		expected = append(expected, x*10, x*10+1, x*10+2)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestThrottleBurst(t *testing.T) {
+	const rate = 20 * time.Millisecond
+
+	start := time.Now()
+	var arrivals []time.Duration
+	err := NewSliceStream([]int{1, 2, 3, 4, 5, 6}).
+		ThrottleBurst(rate, 3).
+		ForEach(func(int) { arrivals = append(arrivals, time.Since(start)) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, elapsed := range arrivals {
+		switch {
+		case i < 3 && elapsed > rate/2:
+			t.Errorf("expected element %d to pass with the burst, arrived after %v", i+1, elapsed)
+		case i >= 3 && elapsed < time.Duration(i-2)*rate-rate/4:
+			t.Errorf("expected element %d to be paced, arrived after %v", i+1, elapsed)
+		}
+	}
+}
+
+func TestTake(t *testing.T) {
+	result, err := NewSliceStream([]int{1, 2, 3, 4, 5}).Take(3).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", result)
+	}
+
+	result, err = NewSliceStream([]int{1, 2}).Take(5).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", result)
+	}
+
+	result, err = NewSliceStream([]int{1, 2}).Take(0).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("expected no elements, got %v", result)
+	}
+}
+
+func TestTakeInfiniteGenerator(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var calls atomic.Int32
+	counter := func() Stream[int, int] {
+		return Generator(func() (int, bool) {
+			return int(calls.Add(1)), true
+		})
+	}
+
+	// Taking straight from the generator never asks it for more
+	result, err := counter().Take(10).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 10 || result[9] != 10 {
+		t.Errorf("expected 1 to 10, got %v", result)
+	}
+	if n := calls.Load(); n != 10 {
+		t.Errorf("expected the generator to be called 10 times, got %d", n)
+	}
+
+	// Behind a push-based stage the generator is stopped once enough
+	// elements went through
+	calls.Store(0)
+	result, err = counter().Map(func(x int) int { return x * 2 }).Take(10).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 10 || result[9] != 20 {
+		t.Errorf("expected 2 to 20, got %v", result)
+	}
+	waitForGoroutines(t, before)
+	stopped := calls.Load()
+	time.Sleep(10 * time.Millisecond)
+	if calls.Load() != stopped {
+		t.Errorf("generator still running after Take completed")
+	}
+}
+
+func TestSampleLast(t *testing.T) {
+	const interval = 30 * time.Millisecond
+
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		// A burst in the first interval, one in the second, none in the third
+		for _, x := range []int{1, 2, 3} {
+			ch <- x
+		}
+		time.Sleep(interval * 3 / 2)
+		for _, x := range []int{4, 5} {
+			ch <- x
+		}
+		time.Sleep(interval * 2)
+	}()
+
+	result, err := NewChanStream(ch).SampleLast(interval).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{3, 5}) {
+		t.Errorf("expected [3 5], got %v", result)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	tests := []struct {
+		n        int
+		expected []int
+	}{
+		{2, []int{3, 4, 5}},
+		{0, []int{1, 2, 3, 4, 5}},
+		{-1, []int{1, 2, 3, 4, 5}},
+		{10, nil},
+	}
+	for _, tt := range tests {
+		result, err := NewSliceStream([]int{1, 2, 3, 4, 5}).Skip(tt.n).Collect(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Skip(%d): expected %v, got %v", tt.n, tt.expected, result)
+		}
+	}
+
+	// Behind a push-based stage
+	result, err := NewSliceStream([]int{1, 2, 3, 4, 5}).Filter(func(x int) bool { return x != 2 }).
+		Skip(2).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{4, 5}) {
+		t.Errorf("expected [4 5], got %v", result)
+	}
+}
+
+func TestSkipThenTake(t *testing.T) {
+	// Pages of 3 through an infinite generator
+	page := func(n int) []int {
+		i := 0
+		gen := Generator(func() (int, bool) {
+			i++
+			return i, true
+		})
+		result, err := gen.Skip(n * 3).Take(3).Collect(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return result
+	}
+
+	if result := page(0); !reflect.DeepEqual(result, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", result)
+	}
+	if result := page(2); !reflect.DeepEqual(result, []int{7, 8, 9}) {
+		t.Errorf("expected [7 8 9], got %v", result)
+	}
+}
+
+func TestTakeWhile(t *testing.T) {
+	result, err := NewSliceStream([]int{1, 2, 3, 4, 5, 1}).
+		Map(func(x int) int { return x * 10 }).
+		TakeWhile(func(x int) bool { return x < 35 }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{10, 20, 30}) {
+		t.Errorf("expected [10 20 30], got %v", result)
+	}
+
+	// An infinite generator terminates
+	before := runtime.NumGoroutine()
+	i := 0
+	result, err = Generator(func() (int, bool) {
+		i++
+		return i, true
+	}).TakeWhile(func(x int) bool { return x <= 4 }).
+		Map(func(x int) int { return x * x }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 4, 9, 16}) {
+		t.Errorf("expected [1 4 9 16], got %v", result)
+	}
+	waitForGoroutines(t, before)
+}
+
+func TestDropWhile(t *testing.T) {
+	result, err := NewSliceStream([]int{1, 2, 3, 4, 1, 2}).
+		DropWhile(func(x int) bool { return x < 3 }).
+		Map(func(x int) int { return x * 10 }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{30, 40, 10, 20}) {
+		t.Errorf("expected [30 40 10 20], got %v", result)
+	}
+
+	result, err = NewSliceStream([]int{1, 2, 3, 4, 1, 2}).
+		Map(func(x int) int { return x * 10 }).
+		DropWhile(func(x int) bool { return x < 30 }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{30, 40, 10, 20}) {
+		t.Errorf("expected [30 40 10 20], got %v", result)
+	}
+}
+
+func TestFlatMap(t *testing.T) {
+	chars := func(s string) []string { return strings.Split(s, "") }
+	words := []string{"go", "", "chain", "map"}
+
+	result, err := FlatMap(NewSliceStream(words), chars).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"g", "o", "c", "h", "a", "i", "n", "m", "a", "p"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	// Character counts are the same under Parallel
+	result, err = FlatMap(NewSliceStream(words).Parallel(3), chars).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	counts := make(map[string]int)
+	for _, c := range result {
+		counts[c]++
+	}
+	if len(result) != 10 || counts["a"] != 2 || counts["g"] != 1 {
+		t.Errorf("unexpected characters %v", result)
+	}
+}
+
+func TestStateMachine(t *testing.T) {
+	// Emits the length of each strictly increasing run once it is broken
+	type run struct {
+		last, length int
+	}
+	step := func(r run, x int) (run, int, bool) {
+		if r.length > 0 && x > r.last {
+			return run{x, r.length + 1}, 0, false
+		}
+		return run{x, 1}, r.length, r.length > 0
+	}
+
+	result, err := StateMachine(NewSliceStream([]int{1, 2, 3, 2, 5, 6, 7, 1, 0}), run{}, step).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The final run [0] is never broken, so it is not reported
+	if !reflect.DeepEqual(result, []int{3, 4, 1}) {
+		t.Errorf("expected [3 4 1], got %v", result)
+	}
+}
+
+// ackRecorder records acknowledged elements and lets a test wait for them
+type ackRecorder struct {
+	acked chan int
+}
+
+func newAckRecorder() *ackRecorder { return &ackRecorder{acked: make(chan int, 100)} }
+
+func (r *ackRecorder) ack(x int) error {
+	r.acked <- x
+	return nil
+}
+
+// wait returns the acked elements once n have been acked, or after a while
+func (r *ackRecorder) wait(n int) []int {
+	var acked []int
+	timeout := time.After(time.Second)
+	for len(acked) < n {
+		select {
+		case x := <-r.acked:
+			acked = append(acked, x)
+		case <-timeout:
+			return acked
+		}
+	}
+	// Give unexpected extra acks a chance to show up
+	time.Sleep(10 * time.Millisecond)
+	for len(r.acked) > 0 {
+		acked = append(acked, <-r.acked)
+	}
+	return acked
+}
+
+func TestWithAck(t *testing.T) {
+	rec := newAckRecorder()
+	failing := func(x int) (int, error) {
+		if x == 3 {
+			return 0, errors.New("processing failed")
+		}
+		return x, nil
+	}
+
+	var processed []int
+	err := MapCircuitBreaker(NewSliceStream([]int{1, 2, 3, 4, 5}), failing, 10, time.Second, nil).
+		WithAck(rec.ack).
+		ForEach(func(x int) { processed = append(processed, x) })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if acked := rec.wait(4); !reflect.DeepEqual(acked, []int{1, 2, 4, 5}) {
+		t.Errorf("expected [1 2 4 5] to be acked, got %v", acked)
+	}
+	if !reflect.DeepEqual(processed, []int{1, 2, 4, 5}) {
+		t.Errorf("expected [1 2 4 5] to be processed, got %v", processed)
+	}
+}
+
+func TestWithAckStoppedEarly(t *testing.T) {
+	rec := newAckRecorder()
+	first, _, err := NewSliceStream([]int{1, 2, 3, 4, 5}).WithAck(rec.ack).First(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != 1 {
+		t.Errorf("expected 1, got %d", first)
+	}
+	// First stopped before the end of the stream, so 1 may not be done with
+	if acked := rec.wait(0); len(acked) != 0 {
+		t.Errorf("expected nothing to be acked, got %v", acked)
+	}
+
+	rec = newAckRecorder()
+	for v, err := range NewSliceStream([]int{1, 2, 3, 4}).WithAck(rec.ack).Seq2(context.Background()) {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if v == 2 {
+			break
+		}
+	}
+	if acked := rec.wait(1); !reflect.DeepEqual(acked, []int{1}) {
+		t.Errorf("expected only [1] to be acked after breaking on 2, got %v", acked)
+	}
+
+	rec = newAckRecorder()
+	errHandle := errors.New("handler failed")
+	err = NewSliceStream([]int{1, 2, 3, 4}).WithAck(rec.ack).ForEachCtx(context.Background(), func(x int) error {
+		if x == 2 {
+			return errHandle
+		}
+		return nil
+	})
+	if !errors.Is(err, errHandle) {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+	if acked := rec.wait(1); !reflect.DeepEqual(acked, []int{1}) {
+		t.Errorf("expected only [1] to be acked after failing on 2, got %v", acked)
+	}
+}
+
+func TestWithAckError(t *testing.T) {
+	errCommit := errors.New("commit failed")
+	var acked []int
+	ack := func(x int) error {
+		if x == 2 {
+			return errCommit
+		}
+		acked = append(acked, x)
+		return nil
+	}
+
+	err := NewSliceStream([]int{1, 2, 3, 4}).WithAck(ack).ForEach(func(int) {})
+	if !errors.Is(err, errCommit) {
+		t.Errorf("expected the ack error, got %v", err)
+	}
+	if !reflect.DeepEqual(acked, []int{1}) {
+		t.Errorf("expected only [1] to be acked, got %v", acked)
+	}
+}
+
+func TestMapErr(t *testing.T) {
+	errThird := errors.New("third element failed")
+	var calls atomic.Int32
+	fn := func(x int) (string, error) {
+		if calls.Add(1) == 3 {
+			return "", errThird
+		}
+		return strings.Repeat("*", x), nil
+	}
+
+	_, err := MapErr(NewSliceStream([]int{1, 2, 3, 4, 5, 6, 7, 8}), fn).Collect(context.Background())
+	if !errors.Is(err, errThird) {
+		t.Fatalf("expected the error of the third element, got %v", err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("expected processing to stop after the third element, fn ran %d times", n)
+	}
+
+	calls.Store(0)
+	err = MapErr(NewSliceStream([]int{1, 2, 3, 4}), fn).ForEach(func(string) {})
+	if !errors.Is(err, errThird) {
+		t.Errorf("expected ForEach to return the error, got %v", err)
+	}
+
+	result, err := MapErr(NewSliceStream([]int{1, 2}), func(x int) (string, error) {
+		return strings.Repeat("*", x), nil
+	}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []string{"*", "**"}) {
+		t.Errorf("expected [* **], got %v", result)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	var seen []int
+	result, err := NewSliceStream([]int{1, 2, 3, 4}).
+		Peek(func(x int) { seen = append(seen, x) }).
+		Filter(func(x int) bool { return x%2 == 0 }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{2, 4}) {
+		t.Errorf("expected [2 4], got %v", result)
+	}
+	if !reflect.DeepEqual(seen, []int{1, 2, 3, 4}) {
+		t.Errorf("expected Peek to observe [1 2 3 4], got %v", seen)
+	}
+
+	seen = nil
+	result, err = NewSliceStream([]int{1, 2, 3}).Lazy().
+		Peek(func(x int) { seen = append(seen, x) }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3}) || !reflect.DeepEqual(seen, result) {
+		t.Errorf("expected lazy Peek to observe and forward [1 2 3], got %v and %v", seen, result)
+	}
+}
+
+func TestScan(t *testing.T) {
+	sums, err := Scan(NewSliceStream([]int{1, 2, 3, 4}), 0, func(acc, x int) int { return acc + x }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(sums, []int{1, 3, 6, 10}) {
+		t.Errorf("expected [1 3 6 10], got %v", sums)
+	}
+
+	prefixes, err := Scan(NewSliceStream([]string{"a", "b", "c"}), ">", func(acc, s string) string { return acc + s }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(prefixes, []string{">a", ">ab", ">abc"}) {
+		t.Errorf("expected [>a >ab >abc], got %v", prefixes)
+	}
+
+	empty, err := Scan(NewSliceStream([]int{}), 0, func(acc, x int) int { return acc + x }).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(empty) != 0 {
+		t.Errorf("expected no output for an empty stream, got %v", empty)
+	}
+}
@@ -0,0 +1,107 @@
+package chain
+
+import "fmt"
+
+// indexed is an element tagged with its position in the source
+type indexed[T any] struct {
+	index int
+	item  T
+}
+
+// dispatchIndexed tags each element read from source with its position, so
+// that parallel workers can report on or restore the original order
+func dispatchIndexed[T any](source <-chan T, quit *signal) <-chan indexed[T] {
+	out := make(chan indexed[T])
+	go func() {
+		defer close(out)
+		index := 0
+		for item := range receive(source, quit) {
+			if !send(out, indexed[T]{index, item}, quit) {
+				return
+			}
+			index++
+		}
+	}()
+	return out
+}
+
+// reorder passes the items of results to emit in index order, holding back
+// the ones that arrive before their predecessors. Indexes must be dense and
+// start at 0, as produced by dispatchIndexed. It stops early once emit
+// returns false.
+func reorder[T any](results <-chan indexed[T], emit func(T) bool) {
+	pending := make(map[int]T)
+	next := 0
+	for r := range results {
+		pending[r.index] = r.item
+		for {
+			item, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			next++
+			if !emit(item) {
+				return
+			}
+		}
+	}
+}
+
+// fanOutOrdered applies fn to the elements of source on s's workers and
+// passes the results to emit in source order, until emit returns false
+func fanOutOrdered[T any, U any, X any, Y any](s *stream[X, Y], stage string, source <-chan T, fn func(worker int, item T) U, emit func(U) bool) {
+	tagged := dispatchIndexed(source, s.quit)
+	results := make(chan indexed[U], s.workers)
+	go func() {
+		defer close(results)
+		s.fanOut(stage, func(worker int) {
+			defer s.recoverPanic(stage)
+			for v := range tagged {
+				if !send(results, indexed[U]{v.index, fn(worker, v.item)}, s.quit) {
+					return
+				}
+			}
+		})
+	}()
+	reorder(results, emit)
+}
+
+// IndexedError reports the position in its input stream of the element that
+// caused Err
+type IndexedError struct {
+	Index int
+	Err   error
+}
+
+func (e *IndexedError) Error() string { return fmt.Sprintf("element %d: %v", e.Index, e.Err) }
+
+func (e *IndexedError) Unwrap() error { return e.Err }
+
+// MapErrIndexed transforms elements with a fallible fn. The first failure
+// stops the pipeline, and the error surfaced by the terminal operation is an
+// *IndexedError carrying the zero-based position of the failing element in
+// s, which holds under Parallel as well.
+func MapErrIndexed[T any, R any](s Stream[T, T], fn func(T) (R, error)) Stream[R, R] {
+	in := asStream(s)
+	source := dispatchIndexed(in.channel(), in.quit)
+	out := make(chan R, in.workers)
+
+	go func() {
+		defer close(out)
+		in.fanOut("MapErrIndexed", func(int) {
+			for v := range source {
+				result, err := fn(v.item)
+				if err != nil {
+					in.abort(&IndexedError{Index: v.index, Err: err})
+					return
+				}
+				if !send(out, result, in.quit) {
+					return
+				}
+			}
+		})
+	}()
+
+	return derive(in, out)
+}
@@ -0,0 +1,100 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMapErrIndexed(t *testing.T) {
+	input := make([]int, 50)
+	for i := range input {
+		input[i] = i * 10
+	}
+	errBad := errors.New("bad element")
+
+	_, err := MapErrIndexed(NewSliceStream(input).Parallel(4), func(x int) (int, error) {
+		if x == 70 {
+			return 0, errBad
+		}
+		return x, nil
+	}).Collect(context.Background())
+
+	var indexErr *IndexedError
+	if !errors.As(err, &indexErr) {
+		t.Fatalf("expected an *IndexedError, got %v", err)
+	}
+	if indexErr.Index != 7 {
+		t.Errorf("expected index 7, got %d", indexErr.Index)
+	}
+	if !errors.Is(err, errBad) {
+		t.Errorf("expected error to unwrap to errBad, got %v", err)
+	}
+}
+
+func TestReorderParallelExpansions(t *testing.T) {
+	// Earlier elements take longer, so workers finish them last
+	in := asStream(NewSliceStream([]int{1, 2, 3, 4, 5, 6}).Parallel(3))
+	tagged := dispatchIndexed(in.channel(), in.quit)
+	results := make(chan indexed[[]int], in.workers)
+	go func() {
+		defer close(results)
+		in.fanOut("test", func(int) {
+			for v := range tagged {
+				time.Sleep(time.Duration(7-v.item) * 5 * time.Millisecond)
+				results <- indexed[[]int]{v.index, []int{v.item * 10, v.item*10 + 1, v.item*10 + 2}}
+			}
+		})
+	}()
+
+	var result []int
+	reorder(results, func(expansion []int) bool {
+		result = append(result, expansion...)
+		return true
+	})
+
+	var expected []int
+	for x := 1; x <= 6; x++ {
+		expected = append(expected, x*10, x*10+1, x*10+2)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestOrderedParallel(t *testing.T) {
+	input := make([]int, 50)
+	for i := range input {
+		input[i] = i
+	}
+	// Uneven work, so that workers finish out of order
+	slow := func(x int) {
+		time.Sleep(time.Duration((x*7)%5) * time.Millisecond)
+	}
+
+	result, err := NewSliceStream(input).OrderedParallel(3).
+		Map(func(x int) int {
+			slow(x)
+			return x * 10
+		}).
+		Filter(func(x int) bool {
+			slow(x / 10)
+			return x%20 == 0
+		}).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var expected []int
+	for _, x := range input {
+		if x%2 == 0 {
+			expected = append(expected, x*10)
+		}
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
@@ -0,0 +1,68 @@
+package chain
+
+import (
+	"reflect"
+	"sync"
+)
+
+// Hop records that an element was handled by a worker of a parallel stage
+type Hop struct {
+	Stage  string
+	Worker int
+}
+
+// provenance holds the hops of the elements of a pipeline, keyed by value
+type provenance struct {
+	mu   sync.Mutex
+	hops map[any][]Hop
+}
+
+// record notes that worker of stage turned in into out. Values that cannot
+// be used as map keys are not recorded. Methods on a nil provenance do
+// nothing, so stages need not check whether provenance is enabled.
+func (p *provenance) record(stage string, worker int, in, out any) {
+	if p == nil || !hashable(in) || !hashable(out) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	prev := p.hops[in]
+	hops := make([]Hop, len(prev), len(prev)+1)
+	copy(hops, prev)
+	p.hops[out] = append(hops, Hop{Stage: stage, Worker: worker})
+}
+
+// lookup returns the hops recorded for v
+func (p *provenance) lookup(v any) []Hop {
+	if p == nil || !hashable(v) {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hops[v]
+}
+
+// hashable reports whether v can be used as a map key
+func hashable(v any) bool {
+	return v != nil && reflect.TypeOf(v).Comparable()
+}
+
+// WithProvenance implements Stream.WithProvenance. Traces are keyed by
+// element value: equal elements share one, which belongs to whichever of
+// them was handled last, even if they are at different stages; elements of
+// types that can't be map keys are not traced. Sequential stages and stages
+// other than Map and Filter leave no hops. Recording takes a lock per
+// element, so this is meant for debugging only. Like Parallel it returns a
+// copy, leaving s unchanged.
+func (s *stream[T, R]) WithProvenance() Stream[T, R] {
+	c := s.clone()
+	c.trace = &provenance{hops: make(map[any][]Hop)}
+	return c
+}
+
+// Provenance implements Stream.Provenance. It returns the parallel stages
+// elem went through, oldest first, and which worker handled it in each.
+func (s *stream[T, R]) Provenance(elem T) []Hop {
+	return s.trace.lookup(elem)
+}
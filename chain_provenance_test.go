@@ -0,0 +1,55 @@
+package chain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWithProvenance(t *testing.T) {
+	input := make([]int, 200)
+	for i := range input {
+		input[i] = i
+	}
+
+	stream := NewSliceStream(input).
+		WithProvenance().
+		Parallel(4).
+		// Keep the mapped values apart from the inputs, as traces are keyed
+		// by value
+		Map(func(x int) int {
+			time.Sleep(100 * time.Microsecond)
+			return x + 1000
+		}).
+		Filter(func(x int) bool { return x%2 == 0 })
+
+	result, err := stream.Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 100 {
+		t.Fatalf("expected 100 elements, got %d", len(result))
+	}
+
+	workers := make(map[int]bool)
+	for _, x := range result {
+		hops := stream.Provenance(x)
+		if len(hops) != 2 || hops[0].Stage != "Map" || hops[1].Stage != "Filter" {
+			t.Fatalf("expected Map and Filter hops for %d, got %v", x, hops)
+		}
+		workers[hops[0].Worker] = true
+	}
+	if len(workers) != 4 {
+		t.Errorf("expected elements to be mapped by all 4 workers, got %v", workers)
+	}
+
+	if hops := stream.Provenance(-1); hops != nil {
+		t.Errorf("expected no hops for an unknown element, got %v", hops)
+	}
+
+	base := NewSliceStream(input)
+	base.WithProvenance()
+	if asStream(base).trace != nil {
+		t.Errorf("expected WithProvenance to leave the receiver unchanged")
+	}
+}
@@ -0,0 +1,191 @@
+package chain
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff describes exponentially growing retry delays. The delay before
+// retry n (starting at 0) is Base*Factor^n, capped at Max, then reduced by a
+// random amount of up to Jitter (a fraction between 0 and 1) of itself so
+// that concurrent retries spread out.
+type Backoff struct {
+	Base   time.Duration
+	Factor float64
+	Max    time.Duration
+	Jitter float64
+}
+
+// DefaultBackoff is used by the retrying operations unless WithBackoff is given
+var DefaultBackoff = Backoff{
+	Base:   10 * time.Millisecond,
+	Factor: 2,
+	Max:    time.Second,
+	Jitter: 0.2,
+}
+
+// Delay returns the delay to wait before retry n, counting from 0
+func (b Backoff) Delay(n int) time.Duration {
+	factor := b.Factor
+	if factor < 1 {
+		factor = 1
+	}
+	d := float64(b.Base) * math.Pow(factor, float64(n))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d -= d * math.Min(b.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// RetryOption configures MapRetry, GeneratorRetry and ForEachRetry
+type RetryOption func(*retryConfig)
+
+type retryConfig struct {
+	backoff Backoff
+}
+
+// WithBackoff sets the delays used between retries
+func WithBackoff(b Backoff) RetryOption {
+	return func(c *retryConfig) { c.backoff = b }
+}
+
+func newRetryConfig(opts []RetryOption) *retryConfig {
+	c := &retryConfig{backoff: DefaultBackoff}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// errStopped is returned by retry when quit fires while waiting
+var errStopped = Error("retry stopped")
+
+// retry calls fn until it succeeds or has been tried attempts times, waiting
+// according to the backoff in between, and returns fn's last error. It gives
+// up early with errStopped when quit fires during a wait.
+func (c *retryConfig) retry(attempts int, quit *signal, fn func() error) error {
+	for n := 0; ; n++ {
+		err := fn()
+		if err == nil || n+1 >= attempts {
+			return err
+		}
+		timer := time.NewTimer(c.backoff.Delay(n))
+		select {
+		case <-timer.C:
+		case <-quit.done():
+			timer.Stop()
+			return errStopped
+		}
+	}
+}
+
+// MapRetry transforms elements with a fallible fn, calling it up to attempts
+// times per element. An element that still fails after the last attempt
+// fails the pipeline with fn's error.
+func MapRetry[T any, R any](s Stream[T, T], fn func(T) (R, error), attempts int, opts ...RetryOption) Stream[R, R] {
+	in := asStream(s)
+	config := newRetryConfig(opts)
+	source := in.channel()
+	out := make(chan R, in.workers)
+
+	go func() {
+		defer close(out)
+		in.fanOut("MapRetry", func(int) {
+			for item := range receive(source, in.quit) {
+				var result R
+				err := config.retry(attempts, in.quit, func() (err error) {
+					result, err = fn(item)
+					return err
+				})
+				if err == errStopped {
+					return
+				}
+				if err != nil {
+					in.abort(err)
+					return
+				}
+				if !send(out, result, in.quit) {
+					return
+				}
+			}
+		})
+	}()
+
+	return derive(in, out)
+}
+
+// GeneratorRetry creates a stream from a fallible generator function. A call
+// that returns an error is retried up to attempts times in total; if it
+// keeps failing the stream ends with that error.
+func GeneratorRetry[T any](gen func() (T, bool, error), attempts int, opts ...RetryOption) Stream[T, T] {
+	config := newRetryConfig(opts)
+	source := make(chan T, 1)
+	s := newSource[T](source)
+
+	go func() {
+		defer close(source)
+		for {
+			var item T
+			var more bool
+			err := config.retry(attempts, s.quit, func() (err error) {
+				item, more, err = gen()
+				return err
+			})
+			if err == errStopped {
+				return
+			}
+			if err != nil {
+				s.state.fail(err)
+				return
+			}
+			if !more || !send(source, item, s.quit) {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// ForEachRetry performs a fallible action for each element, calling fn up to
+// attempts times per element. It stops at the first element that still
+// fails after the last attempt and returns that error.
+func ForEachRetry[T any](s Stream[T, T], fn func(T) error, attempts int, opts ...RetryOption) error {
+	in := asStream(s)
+	defer in.quit.fire()
+
+	config := newRetryConfig(opts)
+	next := in.iter()
+	for item, ok := next(); ok; item, ok = next() {
+		if err := config.retry(attempts, in.quit, func() error { return fn(item) }); err != nil {
+			return err
+		}
+	}
+	return in.state.Err()
+}
+
+// CollectWithPipelineRetry builds a pipeline with rebuild and collects it,
+// rebuilding and collecting again from scratch whenever collection fails, up
+// to attempts times in total. Errors caused by ctx are not retried. It
+// returns the error of the last attempt if none succeeded.
+func CollectWithPipelineRetry[T any](ctx context.Context, attempts int, rebuild func() Stream[T, T], opts ...RetryOption) ([]T, error) {
+	config := newRetryConfig(opts)
+	for n := 0; ; n++ {
+		result, err := rebuild().Collect(ctx)
+		if err == nil || n+1 >= attempts || ctx.Err() != nil {
+			return result, err
+		}
+
+		timer := time.NewTimer(config.backoff.Delay(n))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, contextError(ctx.Err())
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Base: 10 * time.Millisecond, Factor: 2, Max: 100 * time.Millisecond}
+	expected := []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		80 * time.Millisecond,
+		100 * time.Millisecond,
+		100 * time.Millisecond,
+	}
+	for n, want := range expected {
+		if got := b.Delay(n); got != want {
+			t.Errorf("retry %d: expected %v, got %v", n, want, got)
+		}
+	}
+
+	b.Jitter = 0.5
+	for n, want := range expected {
+		for i := 0; i < 100; i++ {
+			got := b.Delay(n)
+			if got > want || got < want/2 {
+				t.Fatalf("retry %d: delay %v outside jitter bounds [%v, %v]", n, got, want/2, want)
+			}
+		}
+	}
+}
+
+var errTransient = errors.New("transient")
+
+// flaky returns a function failing the first n calls for each input
+func flaky(n int) func(int) (int, error) {
+	calls := make(map[int]int)
+	return func(x int) (int, error) {
+		calls[x]++
+		if calls[x] <= n {
+			return 0, errTransient
+		}
+		return x * 10, nil
+	}
+}
+
+func TestMapRetry(t *testing.T) {
+	fast := WithBackoff(Backoff{Base: time.Millisecond, Factor: 2})
+
+	result, err := MapRetry(NewSliceStream([]int{1, 2, 3}), flaky(2), 3, fast).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{10, 20, 30}) {
+		t.Errorf("expected [10 20 30], got %v", result)
+	}
+
+	_, err = MapRetry(NewSliceStream([]int{1, 2, 3}), flaky(3), 3, fast).Collect(context.Background())
+	if !errors.Is(err, errTransient) {
+		t.Errorf("expected errTransient, got %v", err)
+	}
+}
+
+func TestGeneratorRetry(t *testing.T) {
+	calls, count := 0, 0
+	gen := func() (int, bool, error) {
+		// Every other call fails
+		calls++
+		if calls%2 == 1 {
+			return 0, false, errTransient
+		}
+		count++
+		return count, count <= 4, nil
+	}
+
+	result, err := GeneratorRetry(gen, 2, WithBackoff(Backoff{Base: time.Millisecond})).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 4}) {
+		t.Errorf("expected [1 2 3 4], got %v", result)
+	}
+}
+
+func TestForEachRetry(t *testing.T) {
+	fn := flaky(1)
+	var seen []int
+	err := ForEachRetry(NewSliceStream([]int{1, 2, 3}), func(x int) error {
+		v, err := fn(x)
+		if err == nil {
+			seen = append(seen, v)
+		}
+		return err
+	}, 2, WithBackoff(Backoff{Base: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(seen, []int{10, 20, 30}) {
+		t.Errorf("expected [10 20 30], got %v", seen)
+	}
+
+	err = ForEachRetry(NewSliceStream([]int{1}), func(int) error {
+		return errTransient
+	}, 3, WithBackoff(Backoff{Base: time.Millisecond}))
+	if !errors.Is(err, errTransient) {
+		t.Errorf("expected errTransient, got %v", err)
+	}
+}
+
+func TestCollectWithPipelineRetry(t *testing.T) {
+	builds := 0
+	rebuild := func() Stream[int, int] {
+		builds++
+		if builds == 1 {
+			// The first source fails halfway through
+			return MapRetry(NewSliceStream([]int{1, 2, 3}), func(x int) (int, error) {
+				if x == 2 {
+					return 0, errTransient
+				}
+				return x, nil
+			}, 1)
+		}
+		return NewSliceStream([]int{1, 2, 3})
+	}
+
+	result, err := CollectWithPipelineRetry(context.Background(), 3, rebuild,
+		WithBackoff(Backoff{Base: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if builds != 2 {
+		t.Errorf("expected 2 builds, got %d", builds)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", result)
+	}
+
+	builds = 0
+	_, err = CollectWithPipelineRetry(context.Background(), 2, func() Stream[int, int] {
+		builds++
+		return GeneratorRetry(func() (int, bool, error) { return 0, false, errTransient }, 1)
+	}, WithBackoff(Backoff{Base: time.Millisecond}))
+	if !errors.Is(err, errTransient) || builds != 2 {
+		t.Errorf("expected errTransient after 2 builds, got %v after %d", err, builds)
+	}
+}
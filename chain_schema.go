@@ -0,0 +1,160 @@
+package chain
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"unicode/utf8"
+)
+
+// jsonSchema is the subset of JSON Schema understood by ValidateJSONSchema
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []any                  `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+}
+
+// schemaTypes is the type keyword, which is either a name or a list of names
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*t = schemaTypes{name}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(t))
+}
+
+// jsonType returns the JSON Schema type name of a value decoded by
+// encoding/json
+func jsonType(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// validate checks v against the schema, naming the offending location by
+// path in the returned error
+func (s *jsonSchema) validate(v any, path string) error {
+	if len(s.Type) > 0 {
+		actual, ok := jsonType(v), false
+		for _, t := range s.Type {
+			ok = ok || t == actual || t == "number" && actual == "integer"
+		}
+		if !ok {
+			return fmt.Errorf("%s: expected %v, got %s", path, []string(s.Type), actual)
+		}
+	}
+	if len(s.Enum) > 0 {
+		ok := false
+		for _, e := range s.Enum {
+			ok = ok || reflect.DeepEqual(e, v)
+		}
+		if !ok {
+			return fmt.Errorf("%s: %v is not one of %v", path, v, s.Enum)
+		}
+	}
+
+	switch v := v.(type) {
+	case float64:
+		if s.Minimum != nil && v < *s.Minimum {
+			return fmt.Errorf("%s: %v is less than %v", path, v, *s.Minimum)
+		}
+		if s.Maximum != nil && v > *s.Maximum {
+			return fmt.Errorf("%s: %v is greater than %v", path, v, *s.Maximum)
+		}
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.MinLength != nil && n < *s.MinLength {
+			return fmt.Errorf("%s: shorter than %d characters", path, *s.MinLength)
+		}
+		if s.MaxLength != nil && n > *s.MaxLength {
+			return fmt.Errorf("%s: longer than %d characters", path, *s.MaxLength)
+		}
+	case []any:
+		if s.Items != nil {
+			for i, item := range v {
+				if err := s.Items.validate(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		for name, value := range v {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				continue
+			}
+			if err := prop.validate(value, path+"."+name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateJSONSchema passes on the JSON documents of s that are valid
+// against schema, including ones that are not JSON at all. Each invalid
+// document is handed to onInvalid along with the reason it was rejected,
+// for example to route it to a dead-letter queue, and dropped; if onInvalid
+// is nil, the first invalid document fails the pipeline instead. onInvalid
+// runs on the workers of the stage, so concurrently after Parallel. The
+// supported keywords are type, enum, required, properties,
+// additionalProperties, items, minimum, maximum, minLength and maxLength;
+// others are ignored. A schema that cannot be parsed fails the pipeline.
+func ValidateJSONSchema(s Stream[[]byte, []byte], schema string, onInvalid func(doc []byte, err error)) Stream[[]byte, []byte] {
+	in := asStream(s)
+	var root jsonSchema
+	if err := json.Unmarshal([]byte(schema), &root); err != nil {
+		in.abort(fmt.Errorf("chain: invalid JSON schema: %w", err))
+	}
+
+	return in.Filter(func(doc []byte) bool {
+		var v any
+		err := json.Unmarshal(doc, &v)
+		if err == nil {
+			err = root.validate(v, "$")
+		}
+		if err == nil {
+			return true
+		}
+		err = fmt.Errorf("chain: invalid document: %w", err)
+		if onInvalid == nil {
+			in.abort(err)
+		} else {
+			onInvalid(doc, err)
+		}
+		return false
+	})
+}
@@ -0,0 +1,90 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+const userSchema = `{
+	"type": "object",
+	"required": ["name", "age"],
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"tags": {"type": "array", "items": {"type": "string"}}
+	}
+}`
+
+func TestValidateJSONSchema(t *testing.T) {
+	docs := [][]byte{
+		[]byte(`{"name": "ann", "age": 30}`),
+		[]byte(`{"age": 41}`),
+		[]byte(`{"name": "bob", "age": 25, "tags": ["admin"]}`),
+		[]byte(`{"name": "eve", "age": 2.5}`),
+		[]byte(`{"name": "joe", "age": 20, "tags": [1]}`),
+		[]byte(`not json`),
+	}
+
+	var rejected []string
+	result, err := ValidateJSONSchema(NewSliceStream(docs), userSchema, func(doc []byte, err error) {
+		rejected = append(rejected, fmt.Sprintf("%s: %v", doc, err))
+	}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := [][]byte{docs[0], docs[2]}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %q, got %q", expected, result)
+	}
+	expectedRejected := []string{
+		`{"age": 41}: chain: invalid document: $: missing required property "name"`,
+		`{"name": "eve", "age": 2.5}: chain: invalid document: $.age: expected [integer], got number`,
+		`{"name": "joe", "age": 20, "tags": [1]}: chain: invalid document: $.tags[0]: expected [string], got integer`,
+		`not json: chain: invalid document: invalid character 'o' in literal null (expecting 'u')`,
+	}
+	if !reflect.DeepEqual(rejected, expectedRejected) {
+		t.Errorf("expected rejections %q, got %q", expectedRejected, rejected)
+	}
+
+	// Without a callback the first invalid document fails the pipeline
+	_, err = ValidateJSONSchema(NewSliceStream(docs), userSchema, nil).Collect(context.Background())
+	if err == nil || err.Error() != `chain: invalid document: $: missing required property "name"` {
+		t.Errorf("expected the first rejection as the pipeline error, got %v", err)
+	}
+}
+
+func TestValidateJSONSchemaInvalidSchema(t *testing.T) {
+	docs := [][]byte{[]byte(`{}`)}
+	_, err := ValidateJSONSchema(NewSliceStream(docs), `{"type": 1}`, nil).Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected an invalid schema to fail the pipeline")
+	}
+}
+
+func TestJSONSchemaValidate(t *testing.T) {
+	tests := []struct {
+		schema string
+		doc    any
+		valid  bool
+	}{
+		{`{"type": ["string", "null"]}`, nil, true},
+		{`{"type": "number"}`, 3.0, true},
+		{`{"enum": ["a", "b"]}`, "c", false},
+		{`{"maximum": 10}`, 11.0, false},
+		{`{"maxLength": 2}`, "abc", false},
+		{`{"additionalProperties": false, "properties": {"a": {}}}`, map[string]any{"b": 1.0}, false},
+	}
+	for _, tt := range tests {
+		var s jsonSchema
+		if err := json.Unmarshal([]byte(tt.schema), &s); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := s.validate(tt.doc, "$"); (err == nil) != tt.valid {
+			t.Errorf("schema %s on %v: expected valid=%v, got %v", tt.schema, tt.doc, tt.valid, err)
+		}
+	}
+}
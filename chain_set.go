@@ -0,0 +1,85 @@
+package chain
+
+// Union emits every distinct element of a followed by the elements of b that
+// were not in a. Each element is emitted once; the set of emitted elements is
+// kept in memory.
+func Union[T comparable](a, b Stream[T, T]) Stream[T, T] {
+	inputs := []*stream[T, T]{asStream(a), asStream(b)}
+	out := make(chan T, 1)
+	union := join(out, inputs)
+
+	go func() {
+		defer close(out)
+
+		seen := make(map[T]struct{})
+		for _, in := range inputs {
+			for item := range receive(in.channel(), in.quit) {
+				if _, ok := seen[item]; ok {
+					continue
+				}
+				seen[item] = struct{}{}
+				if !send(out, item, union.quit) {
+					return
+				}
+			}
+			if err := in.state.Err(); err != nil {
+				union.abort(err)
+				return
+			}
+		}
+	}()
+
+	return union
+}
+
+// Intersect emits the distinct elements of a that are also in b, in the
+// order they appear in a. b is read into a set before the first element of a
+// is consumed; a is streamed.
+func Intersect[T comparable](a, b Stream[T, T]) Stream[T, T] {
+	return filterBySet(a, b, true)
+}
+
+// Difference emits the distinct elements of a that are not in b, in the
+// order they appear in a. b is read into a set before the first element of a
+// is consumed; a is streamed.
+func Difference[T comparable](a, b Stream[T, T]) Stream[T, T] {
+	return filterBySet(a, b, false)
+}
+
+// filterBySet buffers b into a set, then emits each distinct element of a
+// whose membership in that set equals member
+func filterBySet[T comparable](a, b Stream[T, T], member bool) Stream[T, T] {
+	left, right := asStream(a), asStream(b)
+	out := make(chan T, 1)
+	filtered := join(out, []*stream[T, T]{left, right})
+
+	go func() {
+		defer close(out)
+
+		set := make(map[T]struct{})
+		for item := range right.channel() {
+			set[item] = struct{}{}
+		}
+		if err := right.state.Err(); err != nil {
+			filtered.abort(err)
+			return
+		}
+
+		seen := make(map[T]struct{})
+		for item := range left.channel() {
+			if _, ok := set[item]; ok != member {
+				continue
+			}
+			if _, ok := seen[item]; ok {
+				continue
+			}
+			seen[item] = struct{}{}
+			if !send(out, item, filtered.quit) {
+				return
+			}
+		}
+		filtered.inherit(left.state.Err())
+	}()
+
+	return filtered
+}
@@ -0,0 +1,62 @@
+package chain
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestUnion(t *testing.T) {
+	a := NewSliceStream([]int{1, 2, 3, 2})
+	b := NewSliceStream([]int{3, 4, 5, 4})
+
+	result, err := Union(a, b).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 4, 5}) {
+		t.Errorf("expected [1 2 3 4 5], got %v", result)
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	a := NewSliceStream([]int{5, 1, 2, 3, 4, 3})
+	b := NewSliceStream([]int{3, 4, 5, 6})
+
+	result, err := Intersect(a, b).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{5, 3, 4}) {
+		t.Errorf("expected [5 3 4], got %v", result)
+	}
+}
+
+func TestDifference(t *testing.T) {
+	a := NewSliceStream([]int{1, 2, 3, 4, 1})
+	b := NewSliceStream([]int{3, 4, 5, 6})
+
+	result, err := Difference(a, b).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", result)
+	}
+}
+
+func TestSetOperationErrors(t *testing.T) {
+	failing := func() Stream[int, int] {
+		return Cast[int](NewSliceStream([]any{1, "two"}))
+	}
+
+	_, err := Intersect(NewSliceStream([]int{1, 2}), failing()).Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected the error of the buffered side to fail Intersect")
+	}
+
+	_, err = Union(failing(), NewSliceStream([]int{1, 2})).Collect(context.Background())
+	if err == nil {
+		t.Errorf("expected the error of the first input to fail Union")
+	}
+}
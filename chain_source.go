@@ -0,0 +1,212 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+)
+
+// NewWalkStream creates a stream of the paths found by walking the directory
+// tree rooted at root, in lexical order. Walk errors are surfaced through the
+// terminal operation, and the walk stops as soon as the consumer goes away.
+func NewWalkStream(root string) Stream[string, string] {
+	source := make(chan string, 1)
+	s := newSource[string](source)
+	go func() {
+		defer close(source)
+		err := filepath.WalkDir(root, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !send(source, path, s.quit) {
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			s.state.fail(err)
+		}
+	}()
+	return s
+}
+
+// NewSliceStreamCtx creates a new stream from a slice whose producer stops as
+// soon as ctx is done, failing the pipeline with the context error
+func NewSliceStreamCtx[T any](ctx context.Context, data []T) Stream[T, T] {
+	return bindContext(ctx, asStream(NewSliceStream(data)))
+}
+
+// GeneratorCtx creates a stream from a generator function that is no longer
+// called once ctx is done, failing the pipeline with the context error
+func GeneratorCtx[T any](ctx context.Context, gen func() (T, bool)) Stream[T, T] {
+	return bindContext(ctx, asStream(Generator(gen)))
+}
+
+// NewConsumerStream creates a stream of the messages returned by consume,
+// which blocks until the next message is available. The stream ends cleanly
+// when consume returns ErrNoMoreMessages; any other error fails the
+// pipeline. ctx is passed to consume and also stops the stream, failing it
+// with the context error, like NewSliceStreamCtx.
+func NewConsumerStream[T any](ctx context.Context, consume func(ctx context.Context) (T, error)) Stream[T, T] {
+	s := newIterSource[T](nil, 1)
+	s.next = func() (T, bool) {
+		msg, err := consume(ctx)
+		switch {
+		case err == nil:
+			return msg, true
+		case errors.Is(err, ErrNoMoreMessages):
+		case ctx.Err() != nil:
+			s.state.fail(contextError(ctx.Err()))
+		default:
+			s.state.fail(err)
+		}
+		var zero T
+		return zero, false
+	}
+	return bindContext(ctx, s)
+}
+
+// WithContext implements Stream.WithContext
+func (s *stream[T, R]) WithContext(ctx context.Context) Stream[T, R] {
+	return bindContext(ctx, s)
+}
+
+// bindContext ties the producers of s, and everything upstream of it, to
+// ctx: once ctx is done the pipeline fails with the context error and its
+// quit signal fires, which every stage selects on when receiving and
+// sending, so that an idle source does not hold it up. A stream pulled from
+// an iterator also checks ctx before each element.
+func bindContext[T any, R any](ctx context.Context, s *stream[T, R]) *stream[T, R] {
+	if next := s.next; next != nil {
+		s.next = func() (T, bool) {
+			if err := ctx.Err(); err != nil {
+				s.state.fail(contextError(err))
+				var zero T
+				return zero, false
+			}
+			return next()
+		}
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.state.fail(contextError(ctx.Err()))
+			s.quit.fire()
+		case <-s.quit.done():
+		}
+	}()
+	return s
+}
+
+// LoopGenerator creates a stream that replays generators obtained from
+// factory: whenever one ends, factory is called again for a fresh one, for
+// times rounds in total, or forever if times is negative. The stream also
+// ends when a fresh generator produces nothing, so that looping forever over
+// an empty source doesn't spin.
+func LoopGenerator[T any](times int, factory func() func() (T, bool)) Stream[T, T] {
+	var gen func() (T, bool)
+	rounds := 0
+	fresh, done := false, false
+	return newIterSource(func() (T, bool) {
+		for !done {
+			if gen == nil {
+				if times >= 0 && rounds >= times {
+					break
+				}
+				gen, fresh = factory(), true
+				rounds++
+			}
+			if item, ok := gen(); ok {
+				fresh = false
+				return item, true
+			}
+			done, gen = fresh, nil
+		}
+		done = true
+		var zero T
+		return zero, false
+	}, 1)
+}
+
+// Range creates a stream of the integers from start up to, but excluding,
+// end, stepping by step. A negative step counts down to end instead. It
+// panics if step is zero.
+func Range(start, end, step int) Stream[int, int] {
+	if step == 0 {
+		panic("chain: Range step must not be zero")
+	}
+	i := start
+	return newIterSource(func() (int, bool) {
+		if (step > 0 && i >= end) || (step < 0 && i <= end) {
+			return 0, false
+		}
+		v := i
+		i += step
+		return v, true
+	}, 1)
+}
+
+// Iterate creates an infinite stream of seed, next(seed), next(next(seed))
+// and so on. Use Take or TakeWhile to bound it.
+func Iterate[T any](seed T, next func(T) T) Stream[T, T] {
+	v, started := seed, false
+	return newIterSource(func() (T, bool) {
+		if started {
+			v = next(v)
+		}
+		started = true
+		return v, true
+	}, 1)
+}
+
+// Repeat creates a stream emitting value n times, or forever if n is
+// negative
+func Repeat[T any](value T, n int) Stream[T, T] {
+	emitted := 0
+	return newIterSource(func() (T, bool) {
+		if n >= 0 && emitted >= n {
+			var zero T
+			return zero, false
+		}
+		emitted++
+		return value, true
+	}, 1)
+}
+
+// Cycle creates a stream looping through items forever. An empty items
+// produces an empty stream.
+func Cycle[T any](items []T) Stream[T, T] {
+	i := 0
+	return newIterSource(func() (T, bool) {
+		if len(items) == 0 {
+			var zero T
+			return zero, false
+		}
+		item := items[i]
+		i = (i + 1) % len(items)
+		return item, true
+	}, 1)
+}
+
+// NewChanStreamWithDone creates a stream from ch that ends either when ch is
+// closed or when done is closed, so a shared channel need not be closed to
+// stop it. Elements still buffered in ch once done is closed are left there.
+func NewChanStreamWithDone[T any](ch <-chan T, done <-chan struct{}) Stream[T, T] {
+	return newIterSource(func() (T, bool) {
+		var zero T
+		// done takes precedence over elements that are ready as well
+		select {
+		case <-done:
+			return zero, false
+		default:
+		}
+		select {
+		case item, ok := <-ch:
+			return item, ok
+		case <-done:
+			return zero, false
+		}
+	}, 1)
+}
@@ -0,0 +1,401 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewWalkStream(t *testing.T) {
+	root := t.TempDir()
+	files := []string{
+		"a.txt",
+		filepath.Join("sub", "b.txt"),
+		filepath.Join("sub", "deeper", "c.txt"),
+	}
+	for _, f := range files {
+		path := filepath.Join(root, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("failed to create dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(f), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	result, err := NewWalkStream(root).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{
+		root,
+		filepath.Join(root, "a.txt"),
+		filepath.Join(root, "sub"),
+		filepath.Join(root, "sub", "b.txt"),
+		filepath.Join(root, "sub", "deeper"),
+		filepath.Join(root, "sub", "deeper", "c.txt"),
+	}
+	sort.Strings(result)
+	sort.Strings(expected)
+
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	for i, v := range expected {
+		if result[i] != v {
+			t.Errorf("at index %d: expected %s, got %s", i, v, result[i])
+		}
+	}
+}
+
+func TestNewWalkStreamError(t *testing.T) {
+	_, err := NewWalkStream(filepath.Join(t.TempDir(), "missing")).Collect(context.Background())
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not-exist error, got %v", err)
+	}
+}
+
+func TestNewWalkStreamCancel(t *testing.T) {
+	root := t.TempDir()
+	for i := 0; i < 100; i++ {
+		name := filepath.Join(root, string(rune('a'+i%26))+string(rune('a'+i/26)))
+		if err := os.WriteFile(name, nil, 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewWalkStream(root).Collect(ctx)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("walk did not stop after cancellation")
+	}
+}
+
+// waitForGoroutines waits for the number of goroutines to drop back to n
+func waitForGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked: expected at most %d, got %d", n, runtime.NumGoroutine())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGeneratorCtx(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var calls atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := GeneratorCtx(ctx, func() (int, bool) {
+		return int(calls.Add(1)), true
+	}).Map(func(x int) int { return x * 2 })
+
+	// Nobody consumes the stream; cancelling must still stop the producer
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	waitForGoroutines(t, before)
+
+	calls.Store(0)
+	time.Sleep(10 * time.Millisecond)
+	if got := calls.Load(); got != 0 {
+		t.Errorf("generator still called %d times after cancellation", got)
+	}
+
+	_, err := stream.Collect(context.Background())
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestNewSliceStreamCtx(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result, err := NewSliceStreamCtx(ctx, []int{1, 2, 3}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 3 {
+		t.Errorf("expected 3 elements, got %v", result)
+	}
+
+	cancel()
+	_, err = NewSliceStreamCtx(ctx, []int{1, 2, 3}).Collect(context.Background())
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+	waitForGoroutines(t, before)
+}
+
+// countTo returns a factory of generators producing 1 to n
+func countTo(n int) func() func() (int, bool) {
+	return func() func() (int, bool) {
+		i := 0
+		return func() (int, bool) {
+			i++
+			return i, i <= n
+		}
+	}
+}
+
+func TestLoopGenerator(t *testing.T) {
+	result, err := LoopGenerator(2, countTo(3)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 1, 2, 3}) {
+		t.Errorf("expected [1 2 3 1 2 3], got %v", result)
+	}
+
+	result, err = LoopGenerator(-1, countTo(2)).Take(5).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 1, 2, 1}) {
+		t.Errorf("expected [1 2 1 2 1], got %v", result)
+	}
+
+	// Looping forever over an empty generator ends instead of spinning
+	result, err = LoopGenerator(-1, countTo(0)).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("expected no elements, got %v", result)
+	}
+}
+
+func TestNewChanStreamWithDone(t *testing.T) {
+	ch := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		ch <- 1
+		ch <- 2
+		close(done)
+	}()
+
+	result, err := NewChanStreamWithDone(ch, done).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2}) {
+		t.Errorf("expected [1 2], got %v", result)
+	}
+
+	// Stopping with data still buffered leaves it in the channel
+	buffered := make(chan int, 3)
+	buffered <- 1
+	buffered <- 2
+	buffered <- 3
+	result, err = NewChanStreamWithDone(buffered, done).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("expected no elements, got %v", result)
+	}
+	if len(buffered) != 3 {
+		t.Errorf("expected the buffered elements to stay in the channel, %d left", len(buffered))
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	var produced atomic.Int32
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := Generator(func() (int, bool) {
+		return int(produced.Add(1)), true
+	}).WithContext(ctx).
+		Parallel(4).
+		Map(func(x int) int {
+			time.Sleep(time.Millisecond)
+			return x * 2
+		}).
+		Filter(func(x int) bool { return x%3 != 0 })
+
+	time.AfterFunc(20*time.Millisecond, cancel)
+	err := stream.ForEach(func(int) {})
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+
+	// The workers and the producer are gone and the generator has stopped
+	waitForGoroutines(t, before)
+	stopped := produced.Load()
+	time.Sleep(10 * time.Millisecond)
+	if produced.Load() != stopped {
+		t.Errorf("generator still running after cancellation")
+	}
+}
+
+func TestWithContextIdleSource(t *testing.T) {
+	before := runtime.NumGoroutine()
+	double := func(x int) int { return x * 2 }
+
+	// Nothing ever arrives, so only the bound ctx can end these pipelines
+	for name, run := range map[string]func(Stream[int, int]) error{
+		"Collect": func(s Stream[int, int]) error {
+			_, err := s.Collect(context.Background())
+			return err
+		},
+		"Map": func(s Stream[int, int]) error {
+			_, err := s.Map(double).Collect(context.Background())
+			return err
+		},
+		"Parallel": func(s Stream[int, int]) error {
+			_, err := s.Parallel(4).Map(double).Filter(func(int) bool { return true }).Collect(context.Background())
+			return err
+		},
+		"ForEach": func(s Stream[int, int]) error {
+			return s.Map(double).ForEach(func(int) {})
+		},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		err := run(NewChanStream(make(chan int)).WithContext(ctx))
+		cancel()
+		if !errors.Is(err, ErrDeadline) {
+			t.Errorf("%s: expected ErrDeadline, got %v", name, err)
+		}
+	}
+	waitForGoroutines(t, before)
+}
+
+// fakeConsumer delivers messages one by one, then returns end
+func fakeConsumer(messages []string, end error) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		if len(messages) == 0 {
+			return "", end
+		}
+		msg := messages[0]
+		messages = messages[1:]
+		return msg, nil
+	}
+}
+
+func TestNewConsumerStream(t *testing.T) {
+	messages := []string{"a", "b", "c"}
+	result, err := NewConsumerStream(context.Background(), fakeConsumer(messages, ErrNoMoreMessages)).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, messages) {
+		t.Errorf("expected %v, got %v", messages, result)
+	}
+
+	errBroker := errors.New("broker unavailable")
+	_, err = NewConsumerStream(context.Background(), fakeConsumer(messages, errBroker)).
+		Collect(context.Background())
+	if !errors.Is(err, errBroker) {
+		t.Errorf("expected the consumer error, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewConsumerStream(ctx, fakeConsumer(messages, ErrNoMoreMessages)).
+		Collect(context.Background())
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+}
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		start, end, step int
+		expected         []int
+	}{
+		{0, 5, 1, []int{0, 1, 2, 3, 4}},
+		{1, 10, 3, []int{1, 4, 7}},
+		{5, 0, -2, []int{5, 3, 1}},
+		{3, 3, 1, nil},
+		{5, 0, 1, nil},
+	}
+	for _, tt := range tests {
+		result, err := Range(tt.start, tt.end, tt.step).Collect(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("Range(%d, %d, %d): expected %v, got %v", tt.start, tt.end, tt.step, tt.expected, result)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected Range to panic on a zero step")
+		}
+	}()
+	Range(0, 10, 0)
+}
+
+func TestIterate(t *testing.T) {
+	powers, err := Iterate(1, func(x int) int { return x * 2 }).Take(6).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(powers, []int{1, 2, 4, 8, 16, 32}) {
+		t.Errorf("expected [1 2 4 8 16 32], got %v", powers)
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	result, err := Repeat("x", 3).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []string{"x", "x", "x"}) {
+		t.Errorf("expected [x x x], got %v", result)
+	}
+
+	result, err = Repeat("y", -1).Take(4).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []string{"y", "y", "y", "y"}) {
+		t.Errorf("expected [y y y y], got %v", result)
+	}
+}
+
+func TestCycle(t *testing.T) {
+	result, err := Cycle([]int{1, 2}).Take(5).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 1, 2, 1}) {
+		t.Errorf("expected [1 2 1 2 1], got %v", result)
+	}
+
+	result, err = Cycle([]int{}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 0 {
+		t.Errorf("expected an empty stream, got %v", result)
+	}
+}
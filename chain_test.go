@@ -3,9 +3,16 @@ package chain
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"reflect"
+	"runtime"
+	"runtime/pprof"
 	"sort"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	_ "github.com/glebarez/sqlite"
 )
@@ -108,6 +115,114 @@ func TestParallel(t *testing.T) {
 	}
 }
 
+func TestParallelLeavesReceiverUnchanged(t *testing.T) {
+	base := NewSliceStream([]int{1, 2, 3, 4, 5, 6, 7, 8})
+	wide := base.Parallel(4)
+	ordered := base.OrderedParallel(2)
+
+	for name, tt := range map[string]struct {
+		s       Stream[int, int]
+		workers int
+		ordered bool
+	}{
+		"base":    {base, 1, false},
+		"wide":    {wide, 4, false},
+		"ordered": {ordered, 2, true},
+	} {
+		impl := asStream(tt.s)
+		if impl.workers != tt.workers || impl.ordered != tt.ordered {
+			t.Errorf("%s: expected workers=%d ordered=%v, got workers=%d ordered=%v", name, tt.workers, tt.ordered, impl.workers, impl.ordered)
+		}
+	}
+
+	// The base stream still runs its stages on a single goroutine
+	var running, peak atomic.Int32
+	result, err := base.Map(func(x int) int {
+		n := running.Add(1)
+		defer running.Add(-1)
+		if n > peak.Load() {
+			peak.Store(n)
+		}
+		time.Sleep(time.Millisecond)
+		return x
+	}).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, []int{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("expected the input in order, got %v", result)
+	}
+	if p := peak.Load(); p != 1 {
+		t.Errorf("expected a single worker on the base stream, saw %d at once", p)
+	}
+}
+
+func TestParallelCopiesConsumedTogether(t *testing.T) {
+	input := make([]int, 1000)
+	for i := range input {
+		input[i] = i
+	}
+	for name, base := range map[string]Stream[int, int]{
+		"iterator": NewSliceStream(input),
+		"channel":  NewSliceStream(input).Map(func(x int) int { return x }),
+	} {
+		copies := []Stream[int, int]{base.Parallel(2), base.Parallel(3), base.WithBuffer(4)}
+		results := make([][]int, len(copies))
+		errs := make([]error, len(copies))
+		done := make(chan struct{})
+		for i, c := range copies {
+			go func() {
+				defer func() { done <- struct{}{} }()
+				results[i], errs[i] = c.Map(func(x int) int { return x }).Collect(context.Background())
+			}()
+		}
+		for range copies {
+			<-done
+		}
+
+		var all []int
+		for i := range copies {
+			if errs[i] != nil {
+				t.Fatalf("%s: unexpected error: %v", name, errs[i])
+			}
+			all = append(all, results[i]...)
+		}
+		sort.Ints(all)
+		if !reflect.DeepEqual(all, input) {
+			t.Errorf("%s: expected every element exactly once across the copies, got %d elements", name, len(all))
+		}
+	}
+}
+
+func TestNewChanStreamNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// The channel is never closed, so consumption only ends by cancellation
+	ch := make(chan int, 3)
+	ch <- 1
+	ch <- 2
+	ch <- 3
+
+	first, _, err := NewChanStream(ch).First(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first != 1 {
+		t.Errorf("expected 1, got %d", first)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := NewChanStream(ch).Collect(ctx); !errors.Is(err, ErrDeadline) {
+		t.Errorf("expected ErrDeadline, got %v", err)
+	}
+	if len(ch) != 0 {
+		t.Errorf("expected the remaining elements to be consumed, %d left", len(ch))
+	}
+
+	waitForGoroutines(t, before)
+}
+
 func TestGenerator(t *testing.T) {
 	count := 0
 	gen := func() (int, bool) {
@@ -276,3 +391,563 @@ type User struct {
 	Age   int
 	Score int
 }
+
+func TestLazy(t *testing.T) {
+	input := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	ctx := context.Background()
+
+	t.Run("MapFilterCollect", func(t *testing.T) {
+		result, err := NewSliceStream(input).Lazy().
+			Filter(func(x int) bool { return x%2 == 0 }).
+			Map(func(x int) int { return x * x }).
+			Collect(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected := []int{4, 16, 36, 64, 100}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("expected %v, got %v", expected, result)
+		}
+	})
+
+	t.Run("LeavesReceiverUnchanged", func(t *testing.T) {
+		base := NewSliceStream(input)
+		base.Lazy()
+		if asStream(base).lazy {
+			t.Errorf("expected Lazy to leave the receiver unchanged")
+		}
+	})
+
+	t.Run("Reduce", func(t *testing.T) {
+		sum, err := NewSliceStream(input).Lazy().
+			Map(func(x int) int { return x * 2 }).
+			Reduce(func(a, b int) int { return a + b })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if sum != 110 {
+			t.Errorf("expected 110, got %d", sum)
+		}
+
+		_, err = NewSliceStream([]int{}).Lazy().Reduce(func(a, b int) int { return a + b })
+		if err != ErrEmptyStream {
+			t.Errorf("expected ErrEmptyStream, got %v", err)
+		}
+	})
+
+	t.Run("ForEach", func(t *testing.T) {
+		var seen []int
+		err := NewSliceStream(input).Lazy().
+			Filter(func(x int) bool { return x > 7 }).
+			ForEach(func(x int) { seen = append(seen, x) })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(seen, []int{8, 9, 10}) {
+			t.Errorf("expected [8 9 10], got %v", seen)
+		}
+	})
+
+	t.Run("Generator", func(t *testing.T) {
+		count := 0
+		result, err := Generator(func() (int, bool) {
+			count++
+			return count, count <= 3
+		}).Lazy().Map(func(x int) int { return x + 1 }).Collect(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(result, []int{2, 3, 4}) {
+			t.Errorf("expected [2 3 4], got %v", result)
+		}
+	})
+
+	t.Run("ChanStream", func(t *testing.T) {
+		ch := make(chan int, len(input))
+		for _, v := range input {
+			ch <- v
+		}
+		close(ch)
+		result, err := NewChanStream(ch).Lazy().Collect(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(result, input) {
+			t.Errorf("expected %v, got %v", input, result)
+		}
+	})
+
+	t.Run("Parallel", func(t *testing.T) {
+		result, err := NewSliceStream(input).Lazy().Parallel(3).
+			Map(func(x int) int { return x * 2 }).
+			Collect(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Ints(result)
+		expected := []int{2, 4, 6, 8, 10, 12, 14, 16, 18, 20}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("expected %v, got %v", expected, result)
+		}
+	})
+
+	t.Run("PushStages", func(t *testing.T) {
+		// Stages without a pull implementation fall back to a channel
+		result, err := WindowByCountAggregate(NewSliceStream(input).Lazy(), 5, 0, func(a, x int) int {
+			return a + x
+		}).Map(func(x int) int { return x * 10 }).Collect(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(result, []int{150, 400}) {
+			t.Errorf("expected [150 400], got %v", result)
+		}
+
+		stable, err := CollectStable(ctx, NewSliceStream([]int{3, 1, 2}).Lazy(), func(x int) int { return x })
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(stable, []int{1, 2, 3}) {
+			t.Errorf("expected [1 2 3], got %v", stable)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := NewSliceStream(input).Lazy().Collect(cctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	})
+}
+
+func benchmarkPipeline(b *testing.B, lazy bool) {
+	input := make([]int, 16)
+	for i := range input {
+		input[i] = i
+	}
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := NewSliceStream(input)
+		if lazy {
+			s = s.Lazy()
+		}
+		_, err := s.Filter(func(x int) bool { return x%2 == 0 }).
+			Map(func(x int) int { return x * x }).
+			Collect(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkPushPipeline(b *testing.B) { benchmarkPipeline(b, false) }
+
+func BenchmarkLazyPipeline(b *testing.B) { benchmarkPipeline(b, true) }
+
+func TestCollectContextErrors(t *testing.T) {
+	infinite := func() Stream[int, int] {
+		return Generator(func() (int, bool) {
+			time.Sleep(time.Millisecond)
+			return 1, true
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := infinite().Collect(ctx)
+	if !errors.Is(err, ErrDeadline) {
+		t.Errorf("expected ErrDeadline, got %v", err)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected error to unwrap to context.DeadlineExceeded, got %v", err)
+	}
+	if errors.Is(err, ErrCancelled) {
+		t.Errorf("deadline error should not match ErrCancelled")
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = infinite().Collect(ctx)
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected error to unwrap to context.Canceled, got %v", err)
+	}
+	if errors.Is(err, ErrDeadline) {
+		t.Errorf("cancellation error should not match ErrDeadline")
+	}
+}
+
+func TestWithPprofLabels(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	stream := NewSliceStream([]int{1, 2}).
+		WithPprofLabels().
+		Parallel(2).
+		Map(func(x int) int {
+			started <- struct{}{}
+			<-release
+			return x
+		})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stream.Collect(context.Background())
+		done <- err
+	}()
+
+	// Both workers are now parked inside fn, under their labels
+	<-started
+	<-started
+	var profile strings.Builder
+	if err := pprof.Lookup("goroutine").WriteTo(&profile, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, worker := range []string{"0", "1"} {
+		label := `"chain.stage":"Map", "chain.worker":"` + worker + `"`
+		if !strings.Contains(profile.String(), label) {
+			t.Errorf("expected goroutine profile to contain labels %s", label)
+		}
+	}
+
+	base := NewSliceStream([]int{1})
+	base.WithPprofLabels()
+	if asStream(base).profile {
+		t.Errorf("expected WithPprofLabels to leave the receiver unchanged")
+	}
+}
+
+func TestReduceCtxFold(t *testing.T) {
+	sum := func(_ context.Context, a, b int) (int, error) { return a + b, nil }
+	result, err := NewSliceStream([]int{1, 2, 3, 4}).ReduceCtxFold(context.Background(), sum)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != 10 {
+		t.Errorf("expected 10, got %d", result)
+	}
+
+	// A slow combiner that gives up as soon as ctx is done
+	slow := func(ctx context.Context, a, b int) (int, error) {
+		select {
+		case <-time.After(time.Second):
+			return a + b, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = NewSliceStream([]int{1, 2, 3, 4}).ReduceCtxFold(ctx, slow)
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected ErrCancelled wrapping context.Canceled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the reduction to stop promptly, took %v", elapsed)
+	}
+
+	// A source that delivers one element and then goes quiet
+	idle := make(chan int, 1)
+	idle <- 1
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := NewChanStream(idle).ReduceCtxFold(ctx, sum); !errors.Is(err, ErrDeadline) {
+		t.Errorf("expected ErrDeadline, got %v", err)
+	}
+}
+
+func TestReduceCtx(t *testing.T) {
+	add := func(a, b int) int { return a + b }
+	result, err := NewSliceStream([]int{1, 2, 3, 4}).ReduceCtx(context.Background(), add)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != 10 {
+		t.Errorf("expected 10, got %d", result)
+	}
+
+	if _, err := NewSliceStream([]int{}).ReduceCtx(context.Background(), add); !errors.Is(err, ErrEmptyStream) {
+		t.Errorf("expected ErrEmptyStream, got %v", err)
+	}
+
+	// A slow, infinite generator would otherwise reduce forever
+	slow := Generator(func() (int, bool) {
+		time.Sleep(time.Millisecond)
+		return 1, true
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = slow.ReduceCtx(ctx, add)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the context error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("expected the reduction to stop promptly, took %v", elapsed)
+	}
+}
+
+func TestCollectCancelPromptly(t *testing.T) {
+	// An upstream that produces one element and then stalls
+	calls := 0
+	stalled := Generator(func() (int, bool) {
+		calls++
+		if calls > 1 {
+			time.Sleep(time.Second)
+		}
+		return calls, true
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := stalled.Map(func(x int) int { return x }).Collect(ctx)
+	if !errors.Is(err, ErrCancelled) {
+		t.Errorf("expected ErrCancelled, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("expected Collect to return promptly on cancellation, took %v", elapsed)
+	}
+}
+
+// pollingCollect is the former Collect loop, which polled the source and
+// slept whenever nothing was ready. It is kept for comparison only.
+func pollingCollect[T any](ctx context.Context, s Stream[T, T]) ([]T, error) {
+	in := asStream(s)
+	defer in.quit.fire()
+
+	var result []T
+	source := in.channel()
+	for {
+		select {
+		case item, ok := <-source:
+			if !ok {
+				return result, in.state.Err()
+			}
+			result = append(result, item)
+		case <-ctx.Done():
+			return nil, contextError(ctx.Err())
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// benchmarkCollect collects a short pipeline whose output channel is often
+// momentarily empty, which made the old polling loop sleep
+func benchmarkCollect(b *testing.B, collect func(context.Context, Stream[int, int]) ([]int, error)) {
+	input := make([]int, 100)
+	ctx := context.Background()
+	for i := 0; i < b.N; i++ {
+		s := NewSliceStream(input).Map(func(x int) int { return x + 1 })
+		if _, err := collect(ctx, s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCollect(b *testing.B) {
+	benchmarkCollect(b, func(ctx context.Context, s Stream[int, int]) ([]int, error) {
+		return s.Collect(ctx)
+	})
+}
+
+func BenchmarkCollectPolling(b *testing.B) {
+	benchmarkCollect(b, pollingCollect[int])
+}
+
+func TestPanicRecovery(t *testing.T) {
+	panicOn3 := func(x int) int {
+		if x == 3 {
+			panic("bad value")
+		}
+		return x * 2
+	}
+	input := []int{1, 2, 3, 4, 5, 6, 7, 8}
+
+	cases := map[string]func() Stream[int, int]{
+		"sequential": func() Stream[int, int] { return NewSliceStream(input).Map(panicOn3) },
+		"parallel":   func() Stream[int, int] { return NewSliceStream(input).Parallel(4).Map(panicOn3) },
+		"ordered":    func() Stream[int, int] { return NewSliceStream(input).OrderedParallel(4).Map(panicOn3) },
+		"lazy":       func() Stream[int, int] { return NewSliceStream(input).Lazy().Map(panicOn3) },
+		"filter": func() Stream[int, int] {
+			return NewSliceStream(input).Filter(func(x int) bool { return panicOn3(x) > 0 })
+		},
+		"lazy filter": func() Stream[int, int] {
+			return NewSliceStream(input).Lazy().Filter(func(x int) bool { return panicOn3(x) > 0 })
+		},
+	}
+	for name, build := range cases {
+		_, err := build().Collect(context.Background())
+		if !errors.Is(err, ErrPanic) {
+			t.Errorf("%s: expected an ErrPanic error, got %v", name, err)
+		}
+	}
+
+	err := NewSliceStream(input).ForEach(func(x int) { panicOn3(x) })
+	if !errors.Is(err, ErrPanic) {
+		t.Errorf("ForEach: expected an ErrPanic error, got %v", err)
+	}
+
+	// A lazy stage emits nothing for the element that panicked
+	var seen []int
+	err = NewSliceStream(input).Lazy().Map(panicOn3).ForEach(func(x int) { seen = append(seen, x) })
+	if !errors.Is(err, ErrPanic) {
+		t.Errorf("lazy ForEach: expected an ErrPanic error, got %v", err)
+	}
+	if !reflect.DeepEqual(seen, []int{2, 4}) {
+		t.Errorf("lazy ForEach: expected [2 4], got %v", seen)
+	}
+
+	// and stops an infinite source
+	for name, build := range map[string]func(Stream[int, int]) Stream[int, int]{
+		"map": func(s Stream[int, int]) Stream[int, int] { return s.Map(panicOn3) },
+		"filter": func(s Stream[int, int]) Stream[int, int] {
+			return s.Filter(func(x int) bool { return panicOn3(x) > 0 })
+		},
+		"peek": func(s Stream[int, int]) Stream[int, int] { return s.Peek(func(x int) { panicOn3(x) }) },
+	} {
+		i := 0
+		infinite := Generator(func() (int, bool) {
+			i++
+			return i, true
+		})
+		if _, err := build(infinite.Lazy()).Count(context.Background()); !errors.Is(err, ErrPanic) {
+			t.Errorf("lazy %s on an infinite source: expected an ErrPanic error, got %v", name, err)
+		}
+	}
+
+	cause := errors.New("boom")
+	_, err = NewSliceStream(input).Map(func(int) int { panic(cause) }).Collect(context.Background())
+	if !errors.Is(err, cause) {
+		t.Errorf("expected a panic with an error value to unwrap to it, got %v", err)
+	}
+}
+
+func TestWithBuffer(t *testing.T) {
+	input := make([]int, 100)
+	for i := range input {
+		input[i] = i
+	}
+
+	for _, n := range []int{1, 8, 1000} {
+		result, err := NewSliceStream(input).WithBuffer(n).Parallel(4).
+			Filter(func(x int) bool { return x%3 != 0 }).
+			Map(func(x int) int { return x * 2 }).
+			Collect(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sort.Ints(result)
+		var expected []int
+		for _, x := range input {
+			if x%3 != 0 {
+				expected = append(expected, x*2)
+			}
+		}
+		if !reflect.DeepEqual(result, expected) {
+			t.Errorf("buffer %d: expected %v, got %v", n, expected, result)
+		}
+	}
+
+	base := NewSliceStream(input)
+	_ = base.WithBuffer(64)
+	if asStream(base).capacity() != 1 {
+		t.Errorf("expected WithBuffer to leave the receiver unchanged")
+	}
+}
+
+// BenchmarkWithBuffer runs a deep parallel pipeline with unevenly slow
+// stages, where larger buffers let the stages absorb each other's jitter
+func BenchmarkWithBuffer(b *testing.B) {
+	input := make([]int, 256)
+	for i := range input {
+		input[i] = i
+	}
+	work := func(x int) int {
+		if x%16 == 0 {
+			time.Sleep(10 * time.Microsecond)
+		}
+		return x + 1
+	}
+
+	for _, n := range []int{1, 16, 256} {
+		b.Run(fmt.Sprintf("buffer=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s := NewSliceStream(input).WithBuffer(n).Parallel(2)
+				for stage := 0; stage < 4; stage++ {
+					s = s.Map(work)
+				}
+				if _, err := s.Collect(context.Background()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestForEachCtx(t *testing.T) {
+	var seen []int
+	err := NewSliceStream([]int{1, 2, 3}).ForEachCtx(context.Background(), func(x int) error {
+		seen = append(seen, x)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(seen, []int{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", seen)
+	}
+}
+
+func TestForEachCtxCallbackError(t *testing.T) {
+	errThird := errors.New("third element failed")
+	var seen []int
+	err := NewSliceStream([]int{1, 2, 3, 4, 5}).ForEachCtx(context.Background(), func(x int) error {
+		seen = append(seen, x)
+		if len(seen) == 3 {
+			return errThird
+		}
+		return nil
+	})
+	if !errors.Is(err, errThird) {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+	if !reflect.DeepEqual(seen, []int{1, 2, 3}) {
+		t.Errorf("expected iteration to stop at the third element, saw %v", seen)
+	}
+}
+
+func TestForEachCtxCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	count := 0
+	err := Iterate(1, func(x int) int { return x + 1 }).ForEachCtx(ctx, func(int) error {
+		count++
+		if count == 5 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, ErrCancelled) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected ErrCancelled wrapping context.Canceled, got %v", err)
+	}
+	// Elements already buffered may race with the cancellation for a while
+	if count > 50 {
+		t.Errorf("expected iteration to stop shortly after cancellation, got %d calls", count)
+	}
+}
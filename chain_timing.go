@@ -0,0 +1,75 @@
+package chain
+
+import (
+	"sync"
+	"time"
+)
+
+// stamps holds the times at which WithTiming saw the elements of a pipeline,
+// keyed by value. Equal elements queue up under the same key, oldest first.
+type stamps struct {
+	mu sync.Mutex
+	at map[any][]time.Time
+}
+
+// stamp notes that v passed at t. Like those of provenance, the methods of a
+// nil stamps do nothing, and values that cannot be map keys are ignored.
+func (p *stamps) stamp(v any, t time.Time) {
+	if p == nil || !hashable(v) {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.at[v] = append(p.at[v], t)
+}
+
+// move hands the oldest stamp of in over to out
+func (p *stamps) move(in, out any) {
+	if t, ok := p.take(in); ok {
+		p.stamp(out, t)
+	}
+}
+
+// take removes and returns the oldest stamp of v
+func (p *stamps) take(v any) (time.Time, bool) {
+	if p == nil || !hashable(v) {
+		return time.Time{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	queue := p.at[v]
+	if len(queue) == 0 {
+		return time.Time{}, false
+	}
+	if len(queue) == 1 {
+		delete(p.at, v)
+	} else {
+		p.at[v] = queue[1:]
+	}
+	return queue[0], true
+}
+
+// carryStamps wraps the function of a Map stage so that the stamp of each
+// element moves on to its result
+func carryStamps[T any, R any](p *stamps, fn func(T) R) func(T) R {
+	return func(item T) R {
+		result := fn(item)
+		p.move(item, result)
+		return result
+	}
+}
+
+// WithTiming implements Stream.WithTiming. Stamps are keyed by element value,
+// so Map stages move each one on to the result, and stages that emit other
+// values, such as FlatMap or Batch, leave their elements unstamped. Stamping
+// takes a lock per element, so this is meant for profiling only.
+func (s *stream[T, R]) WithTiming() Stream[T, R] {
+	if s.state.timing == nil {
+		s.state.timing = &stamps{at: make(map[any][]time.Time)}
+	}
+	timing := s.state.timing
+	return s.Peek(func(item T) {
+		timing.stamp(item, time.Now())
+	})
+}
@@ -0,0 +1,201 @@
+package chain
+
+import (
+	"cmp"
+	"time"
+)
+
+// WindowByCountAggregate folds every n consecutive elements into a single
+// aggregate seeded with init, emitting one value per tumbling window. A
+// trailing partial window is emitted when the source ends. It runs
+// sequentially regardless of the parallel setting.
+func WindowByCountAggregate[T any, A any](s Stream[T, T], n int, init A, fn func(A, T) A) Stream[A, A] {
+	if n <= 0 {
+		panic("chain: WindowByCountAggregate window size must be positive")
+	}
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan A, 1)
+
+	go func() {
+		defer close(out)
+
+		acc, count := init, 0
+		for item := range receive(source, in.quit) {
+			acc = fn(acc, item)
+			count++
+			if count == n {
+				if !send(out, acc, in.quit) {
+					return
+				}
+				acc, count = init, 0
+			}
+		}
+		if count > 0 {
+			send(out, acc, in.quit)
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// Batch groups consecutive elements into slices of size elements, flushing a
+// final partial batch when the source ends. It panics if size is not
+// positive. Batching runs sequentially regardless of the parallel setting.
+func Batch[T any](s Stream[T, T], size int) Stream[[]T, []T] {
+	if size <= 0 {
+		panic("chain: Batch size must be positive")
+	}
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan []T, 1)
+
+	go func() {
+		defer close(out)
+
+		batch := make([]T, 0, size)
+		for item := range receive(source, in.quit) {
+			batch = append(batch, item)
+			if len(batch) == size {
+				if !send(out, batch, in.quit) {
+					return
+				}
+				batch = make([]T, 0, size)
+			}
+		}
+		if len(batch) > 0 {
+			send(out, batch, in.quit)
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// Window groups elements into tumbling windows of duration d: every d it
+// emits everything that arrived since the previous window, skipping windows
+// in which nothing arrived, and flushes the elements of the last window when
+// the source ends. It panics if d is not positive.
+func Window[T any](s Stream[T, T], d time.Duration) Stream[[]T, []T] {
+	if d <= 0 {
+		panic("chain: Window duration must be positive")
+	}
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan []T, 1)
+
+	go func() {
+		defer close(out)
+
+		ticker := time.NewTicker(d)
+		defer ticker.Stop()
+
+		var window []T
+		for {
+			select {
+			case item, ok := <-source:
+				if !ok {
+					if len(window) > 0 {
+						send(out, window, in.quit)
+					}
+					return
+				}
+				window = append(window, item)
+			case <-ticker.C:
+				if len(window) == 0 {
+					continue
+				}
+				if !send(out, window, in.quit) {
+					return
+				}
+				window = nil
+			case <-in.quit.done():
+				return
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// SlidingWindow emits windows of size consecutive elements, starting a new
+// window every step elements. Only full windows are emitted, so when step is
+// smaller than size the windows overlap and when it is larger some elements
+// are skipped. It panics if size or step is not positive.
+func SlidingWindow[T any](s Stream[T, T], size, step int) Stream[[]T, []T] {
+	if size <= 0 || step <= 0 {
+		panic("chain: SlidingWindow size and step must be positive")
+	}
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan []T, 1)
+
+	go func() {
+		defer close(out)
+
+		var buf []T
+		skip := 0
+		for item := range receive(source, in.quit) {
+			if skip > 0 {
+				skip--
+				continue
+			}
+			buf = append(buf, item)
+			if len(buf) < size {
+				continue
+			}
+			window := make([]T, size)
+			copy(window, buf)
+			if !send(out, window, in.quit) {
+				return
+			}
+			if step < size {
+				buf = buf[step:]
+			} else {
+				buf, skip = buf[:0], step-size
+			}
+		}
+	}()
+
+	return derive(in, out)
+}
+
+// SlidingMax emits the maximum of the last window elements, once for every
+// element from the point where the first full window is available. It keeps
+// a monotonic deque of candidates, so each update is O(1) amortized. The
+// stage runs sequentially.
+func SlidingMax[T cmp.Ordered](s Stream[T, T], window int) Stream[T, T] {
+	if window <= 0 {
+		panic("chain: SlidingMax window size must be positive")
+	}
+	in := asStream(s)
+	source := in.channel()
+	out := make(chan T, 1)
+
+	go func() {
+		defer close(out)
+
+		// deque holds positions and values whose values are decreasing; the
+		// front is the maximum of the current window
+		type candidate struct {
+			pos   int
+			value T
+		}
+		var deque []candidate
+		pos := 0
+		for item := range receive(source, in.quit) {
+			for len(deque) > 0 && deque[len(deque)-1].value <= item {
+				deque = deque[:len(deque)-1]
+			}
+			deque = append(deque, candidate{pos, item})
+			if deque[0].pos <= pos-window {
+				deque = deque[1:]
+			}
+			pos++
+			if pos >= window && !send(out, deque[0].value, in.quit) {
+				return
+			}
+		}
+	}()
+
+	return in.forward(out)
+}
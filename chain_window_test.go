@@ -0,0 +1,123 @@
+package chain
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWindowByCountAggregate(t *testing.T) {
+	sum := func(acc, x int) int { return acc + x }
+
+	result, err := WindowByCountAggregate(NewSliceStream([]int{1, 2, 3, 4, 5, 6, 7, 8, 9}), 3, 0, sum).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []int{6, 15, 24}
+	if len(result) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, result)
+	}
+	for i, v := range expected {
+		if result[i] != v {
+			t.Errorf("at index %d: expected %d, got %d", i, v, result[i])
+		}
+	}
+
+	// A trailing partial window is still emitted
+	result, err = WindowByCountAggregate(NewSliceStream([]int{1, 2, 3, 4}), 3, 0, sum).
+		Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(result) != 2 || result[0] != 6 || result[1] != 4 {
+		t.Errorf("expected [6 4], got %v", result)
+	}
+}
+
+func TestSlidingMax(t *testing.T) {
+	result, err := SlidingMax(NewSliceStream([]int{1, 3, 2, 5, 4}), 3).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []int{3, 5, 5}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+
+	floats, err := SlidingMax(NewSliceStream([]float64{5, 4, 3, 2, 1, 6}), 2).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(floats, []float64{5, 4, 3, 2, 6}) {
+		t.Errorf("expected [5 4 3 2 6], got %v", floats)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []int
+		expected [][]int
+	}{
+		{"exact multiple", []int{1, 2, 3, 4}, [][]int{{1, 2}, {3, 4}}},
+		{"trailing partial batch", []int{1, 2, 3, 4, 5}, [][]int{{1, 2}, {3, 4}, {5}}},
+		{"empty input", []int{}, nil},
+	}
+	for _, tt := range tests {
+		result, err := Batch(NewSliceStream(tt.input), 2).Collect(context.Background())
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.expected, result)
+		}
+	}
+}
+
+func TestBatchInvalidSize(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("expected Batch to panic for a size of 0")
+		}
+	}()
+	Batch(NewSliceStream([]int{1}), 0)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	result, err := SlidingWindow(NewSliceStream([]int{1, 2, 3, 4, 5, 6}), 4, 2).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := [][]int{{1, 2, 3, 4}, {3, 4, 5, 6}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	const d = 40 * time.Millisecond
+
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		ch <- 1
+		ch <- 2
+		time.Sleep(d * 3 / 2) // past the first tick
+		ch <- 3
+		time.Sleep(d) // past the second tick
+		ch <- 4
+	}()
+
+	result, err := Window(NewChanStream(ch), d).Collect(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := [][]int{{1, 2}, {3}, {4}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("expected %v, got %v", expected, result)
+	}
+}
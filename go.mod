@@ -1,6 +1,6 @@
 module github.com/c4pt0r/chain
 
-go 1.21.1
+go 1.23
 
 require github.com/glebarez/sqlite v1.11.0
 